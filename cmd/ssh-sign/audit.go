@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/audit"
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/policy"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+// Audits the signatures of the commits in a revision range, HEAD by
+// default, and of the annotated tags pointing to them, in the repository
+// in the current directory. Signatures are checked against the allowed
+// signers in gpg.ssh.allowedSignersFile, unless another file is given, and
+// against the signing policy, if one is given.
+//
+// The exit status is non-zero if more objects than the threshold have one
+// of the statuses the audit fails on, so that it can be used in CI.
+//
+//	ssh-sign audit [--format=text|json|junit|sarif] [--workers=<n>] \
+//		[--fail-on=<status>,...] [--threshold=<n>] \
+//		[--allowed-signers=<file>] [--policy=<file>] [<rev-range>...]
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	format := fs.String("format", "text", "Output format, 'text', 'json', 'junit' or 'sarif'")
+	workers := fs.Int("workers", 0, "Number of objects to verify concurrently (default: number of CPUs)")
+	failOn := fs.String("fail-on", joinStatuses(audit.DefaultFailOn), "Comma-separated statuses that count as failures")
+	threshold := fs.Int("threshold", 0, "Number of failures tolerated")
+	allowedSignersFile := fs.String("allowed-signers", "", "Allowed signers file (default: gpg.ssh.allowedSignersFile)")
+	policyFile := fs.String("policy", "", "Signing policy file")
+	fs.Parse(args)
+
+	if *format != "text" && *format != "json" && *format != "junit" && *format != "sarif" {
+		fmt.Printf("Unsupported format, '%s'; try 'text', 'json', 'junit' or 'sarif'.\n", *format)
+		os.Exit(1)
+	}
+
+	statuses, err := parseStatuses(*failOn)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	repo := gitobj.Repo{}
+	opts, err := gitVerifyOptions(repo, *allowedSignersFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var p *policy.Policy
+	if *policyFile != "" {
+		p, err = policy.Load(*policyFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	revs := fs.Args()
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+
+	report, err := audit.Run(repo, revs, audit.Options{
+		Verify:    opts,
+		Workers:   *workers,
+		FailOn:    statuses,
+		Threshold: *threshold,
+		Policy:    p,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "json":
+		err = report.WriteJSON(os.Stdout)
+	case "junit":
+		err = report.WriteJUnit(os.Stdout)
+	case "sarif":
+		err = report.WriteSARIF(os.Stdout)
+	default:
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if !report.Passed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Parse a comma-separated list of statuses. An empty list is allowed, in
+// which case nothing counts as a failure.
+func parseStatuses(list string) ([]verify.Status, error) {
+	statuses := []verify.Status{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !isStatus(verify.Status(s)) {
+			return nil, fmt.Errorf("Unknown status, '%s'; try one of %s.", s, joinStatuses(audit.Statuses))
+		}
+		statuses = append(statuses, verify.Status(s))
+	}
+	return statuses, nil
+}
+
+func isStatus(status verify.Status) bool {
+	for _, s := range audit.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func joinStatuses(statuses []verify.Status) string {
+	s := make([]string, len(statuses))
+	for i, status := range statuses {
+		s[i] = string(status)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/hook"
+	"github.com/Keeper-Security/git-ssh-sign/internal/report"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+// Runs as a server-side git hook, rejecting pushes that introduce commits or
+// tags that are not signed by an allowed signer, or by one of the principals
+// the rules of the configuration file require for the reference. Each
+// rejected object is printed with the reason, which git relays to the
+// client.
+//
+// With verify-push-cert, the push certificate of a signed push is verified
+// instead, and the push rejected unless it is signed by an allowed signer
+// and certifies every update. The verified pusher can be appended to a log
+// file as a JSON line, as an audit trail of who pushed.
+//
+// The configuration file is given with --config or the ssh-sign.hookConfig
+// git config key. Without one, every reference requires a good signature
+// from any signer in gpg.ssh.allowedSignersFile.
+//
+// With --format=sarif or --format=junit, every object that is verified is
+// reported in that format instead, for code scanning and CI dashboards.
+//
+//	ssh-sign hook pre-receive [--config=<file>] [--format=text|sarif|junit]
+//	ssh-sign hook update [--config=<file>] [--format=text|sarif|junit] <ref> <old> <new>
+//	ssh-sign hook verify-push-cert [--config=<file>] [--format=text|json|sarif|junit] [--log=<file>]
+func runHook(args []string) {
+	if len(args) == 0 || (args[0] != "pre-receive" && args[0] != "update" && args[0] != "verify-push-cert") {
+		fmt.Println("Usage: ssh-sign hook pre-receive|update|verify-push-cert [--config=<file>]")
+		os.Exit(1)
+	}
+
+	name := args[0]
+	fs := flag.NewFlagSet("hook "+name, flag.ExitOnError)
+	configFile := fs.String("config", "", "Hook configuration file (default: ssh-sign.hookConfig)")
+	format := fs.String("format", "text", "Output format, 'text', 'sarif' or 'junit', or 'json' for verify-push-cert")
+	logFile := fs.String("log", "", "File to append verified push certificates to, as JSON lines")
+	fs.Parse(args[1:])
+
+	valid, formats := *format == "text" || *format == "sarif" || *format == "junit", "'text', 'sarif' or 'junit'"
+	if name == "verify-push-cert" {
+		valid, formats = valid || *format == "json", "'text', 'json', 'sarif' or 'junit'"
+	}
+	if !valid {
+		fmt.Printf("Unsupported format, '%s'; try %s.\n", *format, formats)
+		os.Exit(1)
+	}
+
+	var updates []hook.Update
+	var err error
+	if name == "update" {
+		if fs.NArg() != 3 {
+			fmt.Println("Usage: ssh-sign hook update [--config=<file>] <ref> <old> <new>")
+			os.Exit(1)
+		}
+		updates = []hook.Update{{Ref: fs.Arg(0), Old: fs.Arg(1), New: fs.Arg(2)}}
+	} else {
+		updates, err = hook.ParseUpdates(os.Stdin)
+		if err != nil {
+			fmt.Printf("ssh-sign: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	repo := gitobj.Repo{}
+	config, opts, err := hookVerifyOptions(repo, *configFile)
+	if err != nil {
+		fmt.Printf("ssh-sign: %s\n", err)
+		os.Exit(1)
+	}
+
+	if name == "verify-push-cert" {
+		verifyPushCert(repo, updates, opts, *format, *logFile)
+	}
+
+	results, err := hook.Verify(repo, config, updates, opts)
+	if err != nil {
+		fmt.Printf("ssh-sign: %s\n", err)
+		os.Exit(1)
+	}
+
+	refs := make([]string, len(updates))
+	for i, u := range updates {
+		refs[i] = u.Ref
+	}
+	run := report.Run{Name: "ssh-sign hook " + name, Target: strings.Join(refs, " ")}
+	rejected := 0
+	for _, r := range results {
+		run.Results = append(run.Results, report.Result{Verification: r.Verification, Ref: r.Ref, Failed: r.Rejected})
+		if !r.Rejected {
+			continue
+		}
+		rejected++
+		if *format == "text" {
+			fmt.Printf("ssh-sign: %s\n", hook.Rejection{Ref: r.Ref, Verification: r.Verification})
+		}
+	}
+
+	if *format != "text" {
+		if err := writeReport(*format, run); err != nil {
+			fmt.Printf("ssh-sign: %s\n", err)
+			os.Exit(1)
+		}
+	} else if rejected > 0 {
+		fmt.Printf("ssh-sign: push rejected, %d objects are not signed as required\n", rejected)
+	}
+
+	if rejected > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Write the run to standard output as SARIF or JUnit XML.
+func writeReport(format string, run report.Run) error {
+	if format == "sarif" {
+		return report.WriteSARIF(os.Stdout, run)
+	}
+	return report.WriteJUnit(os.Stdout, run)
+}
+
+// Verify the push certificate passed by git in the environment, print who
+// pushed, or why the push is rejected, and log the verification if a log
+// file is given. Exit with a non-zero status unless the certificate is good.
+func verifyPushCert(repo gitobj.Repo, updates []hook.Update, opts verify.Options, format, logFile string) {
+	pv := hook.VerifyPushCert(repo, hook.PushCertEnvFrom(os.Getenv), updates, opts)
+
+	b, err := json.Marshal(pv)
+	if err != nil {
+		fmt.Printf("ssh-sign: %s\n", err)
+		os.Exit(1)
+	}
+	if logFile != "" {
+		if err := appendLine(logFile, b); err != nil {
+			fmt.Printf("ssh-sign: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if format == "json" {
+		fmt.Println(string(b))
+	} else if format == "sarif" || format == "junit" {
+		run := report.Run{
+			Name:    "ssh-sign hook verify-push-cert",
+			Target:  pv.Pushee,
+			Results: []report.Result{{Verification: pv.Verification, Failed: pv.Status != verify.StatusGood}},
+		}
+		if err := writeReport(format, run); err != nil {
+			fmt.Printf("ssh-sign: %s\n", err)
+			os.Exit(1)
+		}
+	} else if pv.Status == verify.StatusGood {
+		fmt.Printf("ssh-sign: push certified by %s with %s key %s\n", pv.Principal, pv.KeyType, pv.Fingerprint)
+	} else {
+		fmt.Printf("ssh-sign: push rejected, certificate is %s: %s\n", pv.Status, pv.Reason)
+	}
+
+	if pv.Status != verify.StatusGood {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Append the line to the file, creating it if needed.
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load the hook configuration, and the options to verify signatures with:
+// the allowed signers and revoked keys of the configuration, or else of the
+// repository.
+func hookVerifyOptions(repo gitobj.Repo, configFile string) (*hook.Config, verify.Options, error) {
+	config, err := loadHookConfig(repo, configFile)
+	if err != nil {
+		return nil, verify.Options{}, err
+	}
+
+	opts, err := gitVerifyOptions(repo, config.AllowedSignersFile)
+	if err != nil {
+		return nil, verify.Options{}, err
+	}
+	if config.RevocationFile != "" {
+		opts.Revocations, err = loadRevocations(config.RevocationFile)
+		if err != nil {
+			return nil, verify.Options{}, err
+		}
+	}
+	return config, opts, nil
+}
+
+// Load the hook configuration from the given file, or the file in the
+// ssh-sign.hookConfig git config key. If neither is set, the default rule
+// applies to every reference.
+func loadHookConfig(repo gitobj.Repo, path string) (*hook.Config, error) {
+	if path == "" {
+		var err error
+		path, err = repo.ConfigPath("ssh-sign.hookConfig")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if path == "" {
+		return &hook.Config{}, nil
+	}
+	return hook.LoadConfig(path)
+}
@@ -1,44 +1,117 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/keys"
 	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
-	"github.com/Keeper-Security/git-ssh-sign/internal/vault"
 	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
 	"golang.org/x/crypto/ssh"
 )
 
 func main() {
+	// Subcommands that are run directly, rather than by git, take their own
+	// arguments.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify-commit", "verify-tag":
+			verifyObject(os.Args[1], os.Args[2:])
+		case "audit":
+			runAudit(os.Args[2:])
+		case "hook":
+			runHook(os.Args[2:])
+		case "tofu":
+			runTOFU(os.Args[2:])
+		}
+	}
+
 	var action string
 	var namespace string
 	var inputFile string
 	var signatureFile string
 	var timestamp string
 	var principal string
+	var revocationFile string
+	var format string
+	var useAgent bool
+	var hashAlg string
+	var options optionsFlag
 
 	flag.StringVar(&action, "Y", "", "Action to perform")
-	flag.StringVar(&namespace, "n", "", "Namespace")
+	flag.StringVar(&namespace, "n", "", "Signature namespace, e.g. 'git' or 'file'")
 	flag.StringVar(&inputFile, "f", "", "SSH Key UID or allowed_signers file")
 	flag.StringVar(&signatureFile, "s", "", "Signature file for verification")
-	flag.StringVar(&timestamp, "Overify-time", "", "Timestamp for verification of SSH Key. Not implemented.")
 	flag.StringVar(&principal, "I", "", "Principal to verify")
-	flag.Parse()
+	flag.StringVar(&revocationFile, "r", "", "Revoked keys file or KRL")
+	flag.StringVar(&format, "format", "text", "Output format of verification, 'text' or 'json'")
+	flag.BoolVar(&useAgent, "U", false, "Sign with the key in ssh-agent given by -f, rather than from the Vault")
+	flag.Var(&options, "O", "Option, e.g. 'json', 'hashalg=sha256' or 'verify-time=<timestamp>'")
+	flag.CommandLine.Parse(splitOptions(os.Args[1:]))
 
 	if len(os.Args) == 0 {
 		fmt.Println("This binary is called by git to sign and verify commits with SSH Keys. It is not intended to be ran directly. To setup and use this tool, please refer to the following documentation: https://docs.keeper.io/secrets-manager/secrets-manager/integrations/git-sign-commits-with-ssh")
 		os.Exit(1)
 	}
 
-	// Only the 'git' namespace is supported.
-	if namespace != "" && namespace != "git" {
-		fmt.Printf("Unsupported namespace \"%s\"; use 'git'.\n", namespace)
+	for _, o := range options {
+		if o == "json" {
+			format = "json"
+		} else if strings.HasPrefix(o, "verify-time=") {
+			timestamp = strings.TrimPrefix(o, "verify-time=")
+		} else if strings.HasPrefix(o, "hashalg=") {
+			hashAlg = strings.TrimPrefix(o, "hashalg=")
+		} else {
+			fmt.Printf("Invalid option \"%s\"\n", o)
+			os.Exit(1)
+		}
+	}
+	if format != "text" && format != "json" {
+		fmt.Printf("Unsupported format, '%s'; try 'text' or 'json'.\n", format)
 		os.Exit(1)
 	}
 
+	// Signatures are made with SHA-512, as with ssh-keygen, unless SHA-256
+	// is asked for, e.g. for verifiers that only implement SHA-256. RSA keys
+	// sign with the matching rsa-sha2-256 or rsa-sha2-512 algorithm.
+	if hashAlg == "" {
+		hashAlg = sshsig.DefaultHashAlgorithm
+	}
+	if hashAlg != sshsig.HashSHA256 && hashAlg != sshsig.HashSHA512 {
+		fmt.Printf("Unsupported hash algorithm, '%s'; try '%s' or '%s'.\n", hashAlg, sshsig.HashSHA256, sshsig.HashSHA512)
+		os.Exit(1)
+	}
+
+	// Keys are verified at the time passed by git, typically the commit
+	// timestamp, so that rotated or expired keys are judged correctly. If no
+	// time is given, the current time is used.
+	verifyTime := time.Now()
+	if timestamp != "" {
+		t, err := verify.ParseTime(timestamp)
+		if err != nil {
+			fmt.Printf("Invalid \"verify-time\" option: %s\n", err)
+			os.Exit(1)
+		}
+		verifyTime = t
+	}
+
+	// git always passes the 'git' namespace. Other namespaces, such as
+	// 'file', can be used to sign and verify arbitrary data with the same key.
+	// The namespace is bound to the signature, so a signature made for one
+	// namespace never verifies in another.
+	if namespace == "" {
+		namespace = sign.DefaultNamespace
+	}
+
 	if action == "sign" {
 		/*
 			When the gpg.format = ssh, git calls this program and will pass the
@@ -46,12 +119,14 @@ func main() {
 				-Y sign -n git -f <KEY> /tmp/.git_signing_buffer_file
 
 			The <KEY> is the user.signingkey value from the git config. This
-			will be the UID of the record in the Vault.
+			will be the UID of the record in the Vault, or a URI naming the
+			provider of the key, e.g. keeper://<UID>, agent://SHA256:... or
+			file:///path/to/key.
 			The /tmp/.git_signing_buffer_file is the file that contains the
 			commit data that is to be signed.
 
 			We need to:
-			1. Fetch the private key from the Vault based on the UID.
+			1. Fetch the private key from its provider, e.g. the Vault.
 			2. Sign the commit.
 			3. Write the signature to a file. The file name should be the same
 			as the commit file but with a .sig extension.
@@ -60,59 +135,50 @@ func main() {
 			with the commit, even if incorrectly signed. git wil not verify the
 			signature at the time of commiting. If the exit code is non-zero,
 			git will abort the commit.
-		*/
 
-		// `flag.Args` returns the non-flag arguments, only. In this case, the
-		// first and only argument should be the path to the file that contains
-		// the commit data.
-		commitToSign := flag.Args()[0]
-		if commitToSign == "" {
-			fmt.Println("No commit file specified.")
-			os.Exit(1)
-		}
-
-		keyPair, err := vault.FetchKeys(inputFile)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+			If user.signingkey is a public key, e.g. "key::ssh-ed25519 AAAA...",
+			git writes it to a temporary file, and newer versions of git pass
+			-U, to sign with the key held by ssh-agent instead:
+				-Y sign -n git -f /tmp/.git_signing_key_tmpXXXXXX [-U] /tmp/.git_signing_buffer_file
 
-		file, err := os.Open(commitToSign)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		// Match the permissions of the commit file on the signature file.
-		fileinfo, err := os.Stat(commitToSign)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		fileMode := fileinfo.Mode()
+			As with ssh-keygen, a public key is signed with by ssh-agent even
+			without -U. The key may also be given as a public key or SHA256
+			fingerprint directly, rather than as a file.
+		*/
 
-		sig, err := sign.SignCommit(keyPair.PrivateKey, keyPair.Passphrase, file)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		// `flag.Args` returns the non-flag arguments, only. In this case, they
+		// are the paths to the files to sign; git passes one, the file that
+		// contains the commit data. As with ssh-keygen, without a file, or
+		// with "-", the data is read from stdin, and the signature written to
+		// stdout, e.g. to sign a release artifact:
+		//	ssh-sign -Y sign -n file -f <KEY> < artifact > artifact.sig
+		files := flag.Args()
+		if len(files) == 0 {
+			files = []string{"-"}
 		}
 
-		if err := os.WriteFile(fmt.Sprintf("%s.sig", commitToSign), sig, fileMode); err != nil {
-			fmt.Println(err)
+		registry := keys.Default()
+		failed := signFiles(registry, signingKey(inputFile, useAgent), namespace, hashAlg, files, os.Stdin, os.Stdout, os.Stderr)
+		registry.Close()
+		if failed > 0 {
 			os.Exit(1)
-		} else {
-			os.Exit(0)
 		}
+		os.Exit(0)
 
 	} else if action == "find-principals" {
 		/*
 			When verifying a signature locally, git will begin by collecting
 			all the verified signers from the allowed_signers file that match
-			the git commit passed. If one or more principals are found, hey are
-			returned to stdout.
+			the git commit passed. If one or more principals are found, they
+			are returned to stdout, one allowed signer per line, in the same
+			form as ssh-keygen. git passes the first of them back to the
+			verify action.
 
 			The following arguments are passed to at this stage:
 			-Y find-principals -f <allowed_signers_file> -s <signature_file> -Overify-time=<timestamp>
+
+			With ssh-sign.tofu enabled, the keys trusted on first use are
+			consulted after the allowed signers in the file.
 		*/
 
 		allowedSigners, err := verify.GetAllowedSigners(inputFile)
@@ -121,13 +187,26 @@ func main() {
 			os.Exit(1)
 		}
 
+		store, err := loadTOFU(gitobj.Repo{})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		} else if store != nil {
+			allowedSigners = append(allowedSigners, store.AllowedSigners()...)
+		}
+
 		sig, err := verify.ParseSignatureFile(signatureFile)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		mp, err := verify.GetMatchingPrincipals(allowedSigners, sig)
+		if err := checkRevoked(revocationFile, sig); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		mp, err := verify.GetMatchingPrincipals(allowedSigners, sig, verifyTime)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -146,84 +225,299 @@ func main() {
 	} else if action == "verify" {
 		/*
 			The second stage of the verification process is to verify the
-			signature against the commit data. The fingerprints of the
-			principal are compared to the fingerprints of the public key in
-			the signature file. If they match, the signature is considered
-			verified. Successful verification by an authorized signer is
-			signalled by returning a zero exit status.
+			signature against the commit data. The principal passed by git is
+			the first principal reported by find-principals. It is resolved to
+			the allowed signers whose principals match it, one of which must
+			authorize the public key in the signature file for the namespace
+			at the verify time. The commit data, which git passes on stdin, is
+			checked against the signature itself. Successful verification by
+			an authorized signer is signalled by returning a zero exit status.
 
 			The following arguments are passed to at this stage:
 			-Y verify -n git -f <allowed_signers_file> -I <principal> \
-			-s <signature_file> -Overify-time=<timestamp>
+			-s <signature_file> -Overify-time=<timestamp> [-r <revocation_file>]
 		*/
 
-		sig, err := verify.ParseSignatureFile(signatureFile)
+		allowedSigners, err := verify.GetAllowedSigners(inputFile)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			printResult(errorResult(namespace, err), format)
 		}
 
-		if err := verify.VerifyFingerprints([]byte(principal), sig.PublicKey); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		store, err := loadTOFU(gitobj.Repo{})
+		if err != nil {
+			printResult(errorResult(namespace, err), format)
+		} else if store != nil {
+			allowedSigners = append(allowedSigners, store.AllowedSigners()...)
 		}
 
-		allowedSigners, err := verify.GetAllowedSigners(inputFile)
+		revocations, err := loadRevocations(revocationFile)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			printResult(errorResult(namespace, err), format)
 		}
 
-		// Get email of the matching principal from the allowed_signers file.
-		// This is used to display the email address of the signer in stdout.
-		// Note: it is possible for multiple principals to have the same email
-		// address associated with them. In this case, the first match is used.
-		var principalEmail string
-		for _, p := range allowedSigners {
-			if p.PublicKey == principal {
-				principalEmail = p.Email
-				break
+		armored, err := os.ReadFile(signatureFile)
+		if err != nil {
+			printResult(errorResult(namespace, err), format)
+		}
+
+		// The output mirrors the output of the default ssh git signing method
+		// (ssh-keygen), which git uses to determine if the commit is valid.
+		printResult(verify.Verify(armored, os.Stdin, verify.Options{
+			AllowedSigners: allowedSigners,
+			Principal:      principal,
+			Namespace:      namespace,
+			Time:           verifyTime,
+			Revocations:    revocations,
+		}), format)
+
+	} else if action == "check-novalidate" {
+		// If unable to verify the principal is in the allowed_signers file,
+		// git checks the commit data, passed on stdin, against the public key
+		// in the signature file. If they match, the signature is valid, but
+		// the signer is not verified.
+		//
+		// With ssh-sign.tofu enabled, the key of a good signature is recorded
+		// for the committer or tagger of the commit data, if none is yet, and
+		// the signature fails if a different key was recorded.
+		//
+		// -Y check-novalidate -n git -s <signature_file> -Overify-time=<timestamp>
+		armored, err := os.ReadFile(signatureFile)
+		if err != nil {
+			printResult(errorResult(namespace, err), format)
+		}
+
+		payload, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			printResult(errorResult(namespace, err), format)
+		}
+
+		// As above, the output mirrors the output of ssh-keygen.
+		result := verify.Verify(armored, bytes.NewReader(payload), verify.Options{
+			Namespace:  namespace,
+			Time:       verifyTime,
+			NoValidate: true,
+		})
+
+		store, err := loadTOFU(gitobj.Repo{})
+		if err != nil {
+			printResult(errorResult(namespace, err), format)
+		}
+		if store != nil && result.Status == verify.StatusGood && namespace == sign.DefaultNamespace {
+			if err := trustOnFirstUse(gitobj.Repo{}, store, result, armored, payload); err != nil {
+				printResult(errorResult(namespace, err), format)
 			}
 		}
+		printResult(result, format)
+
+	} else if action == "match-principals" {
+		/*
+			Not used by git, but provided for compatibility with ssh-keygen.
+			Prints the principals of each allowed signer whose principals
+			pattern-list matches the given identity.
+
+			-Y match-principals -I <principal> -f <allowed_signers_file>
+		*/
+		if principal == "" {
+			fmt.Println("Too few arguments for match-principals: missing principal ID")
+			os.Exit(1)
+		}
 
-		// Parse the public key into SSH wire format
-		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(principal))
+		allowedSigners, err := verify.GetAllowedSigners(inputFile)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-		keyType := strings.ToUpper(strings.Split(key.Type(), "-")[1])
 
-		// Output the result to stdout, which will be used by git to determine
-		// if the commit is valid. This output mirrors the output of the
-		// default ssh git signing method (ssh-keygen). This is done to ensure
-		// compatibility with git.
-		fmt.Printf("Good \"%s\" signature for %s with %s key %s\n", namespace, principalEmail, keyType, ssh.FingerprintSHA256(key))
+		mp := verify.MatchPrincipals(allowedSigners, principal)
+		if len(mp) == 0 {
+			fmt.Println("No principal matched.")
+			os.Exit(1)
+		}
+		for _, p := range mp {
+			fmt.Println(p)
+		}
 		os.Exit(0)
 
-	} else if action == "check-novalidate" {
-		// If unable to verify the principal is in the allowed_signers file,
-		// git hecks the principal passed by git against the public key in the
-		// signature file. If they match, the signature is valid, but not
-		// verified.
-		sig, err := verify.ParseSignatureFile(signatureFile)
+	} else {
+		fmt.Printf("Unsupported action, '%s'; try 'check-novalidate', 'find-principals', 'match-principals', 'sign', or 'verify'.\n", action)
+		os.Exit(1)
+	}
+}
+
+// Split the options given in the attached form of ssh-keygen, e.g.
+// -Overify-time=<timestamp> as passed by git, or -Ohashalg=sha256, into
+// -O <option>, as the flag package would take them for flags of their own.
+func splitOptions(args []string) []string {
+	var split []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(split, args[i:]...)
+		}
+		if strings.HasPrefix(arg, "-O") && len(arg) > len("-O") {
+			split = append(split, "-O", strings.TrimPrefix(arg, "-O"))
+		} else {
+			split = append(split, arg)
+		}
+	}
+	return split
+}
+
+// Returns the signing key, as a URI of its provider. With -U, as passed by
+// git when user.signingkey is a key in ssh-agent, a key without a scheme is
+// in ssh-agent.
+func signingKey(key string, useAgent bool) string {
+	if useAgent && !strings.Contains(key, "://") {
+		_, ref := keys.ParseRef(key)
+		key = keys.SchemeAgent + "://" + ref
+	}
+	return key
+}
+
+// Sign the files with the key, which is fetched once however many files are
+// signed, e.g. the artifacts of a release, and return the number of files
+// that could not be signed. The file "-" is stdin, whose signature is written
+// to stdout. Errors are written to stdout, as git expects, or to stderr if
+// stdout is a signature.
+func signFiles(registry *keys.Registry, key, namespace, hashAlg string, files []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	out := stdout
+	var stdins int
+	for _, f := range files {
+		if f == "-" {
+			out = stderr
+			stdins++
+		}
+	}
+	if stdins > 1 {
+		fmt.Fprintln(out, "stdin ('-') can only be signed once.")
+		return len(files)
+	}
+
+	as, err := registry.Signer(key)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return len(files)
+	}
+
+	var failed int
+	for _, f := range files {
+		if err := signFile(as, namespace, hashAlg, f, stdin, stdout); errors.Is(err, errNoFile) {
+			fmt.Fprintln(out, "No file specified.")
+			failed++
+		} else if err != nil {
+			fmt.Fprintln(out, err)
+			failed++
+		}
+	}
+	if failed > 0 && len(files) > 1 {
+		fmt.Fprintf(out, "Failed to sign %d of %d files.\n", failed, len(files))
+	}
+	return failed
+}
+
+var errNoFile = errors.New("no file specified")
+
+// Sign the file, hashing it with the hash algorithm, and write the signature
+// to <file>.sig, with the permissions of the file. The file "-" is stdin,
+// and its signature is written to stdout.
+func signFile(as ssh.AlgorithmSigner, namespace, hashAlg, path string, stdin io.Reader, stdout io.Writer) error {
+	if path == "" {
+		return errNoFile
+	}
+
+	if path == "-" {
+		sig, err := sign.Sign(as, namespace, hashAlg, stdin)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return err
 		}
-		keyType := strings.ToUpper(strings.Split(sig.PublicKey.Type(), "-")[1])
+		_, err = stdout.Write(sig)
+		return err
+	}
 
-		// As above, this output mirrors the output of the default ssh git
-		// signing method (ssh-keygen). This is done to ensure compatibility
-		// with git.
-		fmt.Printf("Good \"%s\" signature with %s key %s\n", namespace, keyType, ssh.FingerprintSHA256(sig.PublicKey))
-		fmt.Println("No matching principal")
-		os.Exit(0)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Match the permissions of the file on the signature file.
+	fileinfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	sig, err := sign.Sign(as, namespace, hashAlg, file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return os.WriteFile(fmt.Sprintf("%s.sig", path), sig, fileinfo.Mode())
+}
+
+// Load the revocation file, if one was given, i.e. gpg.ssh.revocationFile is
+// set.
+func loadRevocations(revocationFile string) (*verify.RevocationList, error) {
+	if revocationFile == "" {
+		return nil, nil
+	}
+
+	rl, err := verify.GetRevocationList(revocationFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading revocation file %s: %w", revocationFile, err)
+	}
+	return rl, nil
+}
+
+// If a revocation file was given, check that the key in the signature has not
+// been revoked.
+func checkRevoked(revocationFile string, sig *verify.Signature) error {
+	rl, err := loadRevocations(revocationFile)
+	if err != nil || rl == nil {
+		return err
+	}
+	if err := rl.CheckKey(sig.PublicKey); err != nil {
+		return fmt.Errorf("Signature %w", err)
+	}
+	return nil
+}
 
+// Print the result of verification, as ssh-keygen would or as JSON, and exit
+// with a non-zero status unless the signature is good.
+func printResult(result *verify.Result, format string) {
+	if format == "json" {
+		b, err := json.Marshal(result)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(b))
 	} else {
-		// The only unsupported action is 'match-principals' as it is not used
-		// by git.
-		fmt.Printf("Unsupported action, '%s'; try 'check-novalidate', 'find-principals', 'sign', or 'verify'.\n", action)
+		fmt.Println(result.Text())
+	}
+
+	if result.Status != verify.StatusGood {
 		os.Exit(1)
 	}
+	os.Exit(0)
+}
+
+// The result of a verification that failed before the signature was checked,
+// e.g. because the allowed signers file could not be read.
+func errorResult(namespace string, err error) *verify.Result {
+	return &verify.Result{
+		Status:    verify.StatusError,
+		Namespace: namespace,
+		Reason:    err.Error(),
+		Err:       err,
+	}
+}
+
+// ssh-keygen style options, given with -O and possibly repeated.
+type optionsFlag []string
+
+func (o *optionsFlag) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *optionsFlag) Set(value string) error {
+	*o = append(*o, value)
+	return nil
 }
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/keys"
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/internal/tofu"
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
+	"golang.org/x/crypto/ssh"
+)
+
+// The test binary runs main instead of the tests if this variable is set, so
+// that tests can check the exit status of a command.
+const runMainEnv = "SSH_SIGN_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Run ssh-sign with the arguments and stdin, in the home directory, which
+// holds the git configuration instead of that of the user, returning its
+// stdout and exit status.
+func runMain(t *testing.T, home string, stdin []byte, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = home
+	cmd.Env = append(os.Environ(), runMainEnv+"=1", "HOME="+home, "XDG_CONFIG_HOME="+home, "GIT_CONFIG_NOSYSTEM=1")
+	cmd.Stdin = bytes.NewReader(stdin)
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(out), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), 0
+}
+
+// A provider of a single key, which counts how often it is fetched.
+type countingProvider struct {
+	signer  ssh.AlgorithmSigner
+	fetched int
+}
+
+func (p *countingProvider) Signer(ref string) (ssh.AlgorithmSigner, error) {
+	p.fetched++
+	return p.signer, nil
+}
+
+func newTestRegistry(t *testing.T) (*keys.Registry, *countingProvider) {
+	p := &countingProvider{signer: testutil.NewSigner(t)}
+	r := keys.NewRegistry()
+	r.Register("test", p)
+	return r, p
+}
+
+// Check that the armored signature is a good signature of the data by the
+// signer, in the file namespace.
+func checkSignature(t *testing.T, signer ssh.Signer, armored, data []byte) {
+	t.Helper()
+	sig, err := sshsig.Unarmor(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sshsig.Verify(sig, signer.PublicKey(), "file", bytes.NewReader(data)); err != nil {
+		t.Errorf("Verify returned an error: %v", err)
+	}
+}
+
+func TestSignFiles(t *testing.T) {
+	registry, p := newTestRegistry(t)
+	dir := t.TempDir()
+
+	var files []string
+	for name, mode := range map[string]os.FileMode{"public": 0644, "private": 0600, "group": 0640} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(path), mode); err != nil {
+			t.Fatal(err)
+		}
+		// The mode of the file is subject to the umask.
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if failed := signFiles(registry, "test://key", "file", sshsig.HashSHA512, files, nil, &stdout, &stderr); failed != 0 {
+		t.Fatalf("signFiles failed to sign %d files: %s", failed, stdout.String())
+	}
+	if p.fetched != 1 {
+		t.Errorf("signFiles fetched the key %d times, expected once", p.fetched)
+	}
+
+	for _, path := range files {
+		armored, err := os.ReadFile(path + ".sig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSignature(t, p.signer, armored, []byte(path))
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigInfo, err := os.Stat(path + ".sig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sigInfo.Mode() != info.Mode() {
+			t.Errorf("signFiles wrote %s.sig with mode %v, expected %v", path, sigInfo.Mode(), info.Mode())
+		}
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("signFiles wrote %q to stdout and %q to stderr", stdout.String(), stderr.String())
+	}
+}
+
+func TestSignFilesMissing(t *testing.T) {
+	registry, p := newTestRegistry(t)
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	if err := os.WriteFile(good, []byte("good"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	var stdout, stderr bytes.Buffer
+	if failed := signFiles(registry, "test://key", "file", sshsig.HashSHA512, []string{missing, "", good}, nil, &stdout, &stderr); failed != 2 {
+		t.Fatalf("signFiles failed to sign %d files, expected 2", failed)
+	}
+	if !strings.Contains(stdout.String(), missing) || !strings.Contains(stdout.String(), "No file specified.\n") || !strings.Contains(stdout.String(), "Failed to sign 2 of 3 files.") {
+		t.Errorf("signFiles wrote %q", stdout.String())
+	}
+
+	// The files after the one that failed are signed.
+	armored, err := os.ReadFile(good + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSignature(t, p.signer, armored, []byte("good"))
+	if _, err := os.Stat(missing + ".sig"); !os.IsNotExist(err) {
+		t.Errorf("signFiles wrote a signature of a missing file: %v", err)
+	}
+}
+
+func TestSignFilesStdin(t *testing.T) {
+	registry, p := newTestRegistry(t)
+	data := []byte("release artifact\n")
+
+	var stdout, stderr bytes.Buffer
+	if failed := signFiles(registry, "test://key", "file", sshsig.HashSHA512, []string{"-"}, bytes.NewReader(data), &stdout, &stderr); failed != 0 {
+		t.Fatalf("signFiles failed to sign stdin: %s", stderr.String())
+	}
+	checkSignature(t, p.signer, stdout.Bytes(), data)
+
+	// Stdin can only be read once, and nothing is signed otherwise.
+	stdout.Reset()
+	files := []string{"-", filepath.Join(t.TempDir(), "file"), "-"}
+	if failed := signFiles(registry, "test://key", "file", sshsig.HashSHA512, files, bytes.NewReader(data), &stdout, &stderr); failed != len(files) {
+		t.Errorf("signFiles failed to sign %d files, expected %d", failed, len(files))
+	}
+	if p.fetched != 1 || stdout.Len() != 0 || !strings.Contains(stderr.String(), "only be signed once") {
+		t.Errorf("signFiles fetched the key %d times and wrote %q to stdout and %q to stderr", p.fetched, stdout.String(), stderr.String())
+	}
+}
+
+func TestCheckNoValidate(t *testing.T) {
+	payload := "tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904\n" +
+		"author A U Thor <author@example.com> 1700000000 +0000\n" +
+		"committer A U Thor <author@example.com> 1700000000 +0000\n\nAdd a feature\n"
+	signatureFile := filepath.Join(t.TempDir(), "commit.sig")
+	if err := os.WriteFile(signatureFile, []byte(testutil.Sign(t, testutil.NewSigner(t), payload)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		payload string
+		status  int
+		output  string
+	}{
+		{"good", payload, 0, "Good \"git\" signature with ED25519 key"},
+		{"tampered", strings.Replace(payload, "Add a feature", "Add a backdoor", 1), 1, "Signature verification failed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, status := runMain(t, t.TempDir(), []byte(tt.payload), "-Y", "check-novalidate", "-n", "git", "-s", signatureFile)
+			if status != tt.status || !strings.Contains(out, tt.output) {
+				t.Errorf("check-novalidate exited with %d and wrote %q, expected %d and %q", status, out, tt.status, tt.output)
+			}
+		})
+	}
+}
+
+func TestCheckNoValidateTOFU(t *testing.T) {
+	home := t.TempDir()
+	alice := testutil.NewSigner(t)
+	allowedSigners := filepath.Join(home, "allowed_signers")
+	if err := os.WriteFile(allowedSigners, []byte("alice@example.com "+testutil.AuthorizedKey(alice.PublicKey())+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := filepath.Join(home, "tofu")
+	config := filepath.Join(home, ".gitconfig")
+	testutil.Git(t, home, "", "config", "--file", config, "ssh-sign.tofu", "true")
+	testutil.Git(t, home, "", "config", "--file", config, "ssh-sign.tofuFile", store)
+	testutil.Git(t, home, "", "config", "--file", config, "gpg.ssh.allowedSignersFile", allowedSigners)
+
+	// Someone else signing as alice, who is in the allowed signers file, is
+	// not trusted, while a new committer is.
+	other := testutil.NewSigner(t)
+	for _, tt := range []struct {
+		email  string
+		status int
+	}{
+		{"alice@example.com", 1},
+		{"bob@example.com", 0},
+	} {
+		t.Run(tt.email, func(t *testing.T) {
+			payload := "tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904\n" +
+				"author A U Thor <" + tt.email + "> 1700000000 +0000\n" +
+				"committer A U Thor <" + tt.email + "> 1700000000 +0000\n\nAdd a feature\n"
+			signatureFile := filepath.Join(t.TempDir(), "commit.sig")
+			if err := os.WriteFile(signatureFile, []byte(testutil.Sign(t, other, payload)), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if out, status := runMain(t, home, []byte(payload), "-Y", "check-novalidate", "-n", "git", "-s", signatureFile); status != tt.status {
+				t.Errorf("check-novalidate exited with %d and wrote %q, expected %d", status, out, tt.status)
+			}
+		})
+	}
+
+	s, err := tofu.Load(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Trusted("alice@example.com") != nil || s.Trusted("bob@example.com") == nil {
+		t.Errorf("check-novalidate recorded %+v", s.Entries)
+	}
+}
+
+func TestAttachedOptions(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := filepath.Join(dir, "key")
+	if err := os.WriteFile(key, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(dir, "data")
+	if err := os.WriteFile(data, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// As with ssh-keygen, options may be attached to -O.
+	if out, status := runMain(t, dir, nil, "-Y", "sign", "-n", "file", "-f", "file://"+key, "-Ohashalg=sha256", data); status != 0 {
+		t.Fatalf("sign exited with %d and wrote %q", status, out)
+	}
+	armored, err := os.ReadFile(data + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := sshsig.Unarmor(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.HashAlgorithm != sshsig.HashSHA256 {
+		t.Errorf("sign hashed the data with %s, expected %s", sig.HashAlgorithm, sshsig.HashSHA256)
+	}
+
+	// git passes -Overify-time attached, too.
+	out, status := runMain(t, dir, []byte("data"), "-Y", "check-novalidate", "-n", "file", "-s", data+".sig", "-Ojson", "-Overify-time=20240101000000")
+	var result struct{ Status string }
+	if err := json.Unmarshal([]byte(out), &result); err != nil || status != 0 || result.Status != "good" {
+		t.Errorf("check-novalidate exited with %d and wrote %q", status, out)
+	}
+}
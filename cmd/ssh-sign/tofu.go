@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/tofu"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+// Lists the keys in the trust-on-first-use store, or accepts the changed key
+// of a principal, replacing the key first seen for it. The fingerprint of
+// the key to accept is only needed if more than one key has changed.
+//
+//	ssh-sign tofu list [--file=<store>]
+//	ssh-sign tofu accept [--file=<store>] <principal> [<fingerprint>]
+func runTOFU(args []string) {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "accept") {
+		fmt.Println("Usage: ssh-sign tofu list|accept [--file=<store>]")
+		os.Exit(1)
+	}
+
+	name := args[0]
+	fs := flag.NewFlagSet("tofu "+name, flag.ExitOnError)
+	file := fs.String("file", "", "Trust-on-first-use store (default: ssh-sign.tofuFile)")
+	fs.Parse(args[1:])
+
+	path, err := tofuPath(gitobj.Repo{}, *file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if name == "list" {
+		store, err := tofu.Load(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, e := range store.Entries {
+			status := "trusted"
+			if e.Changed {
+				status = "changed"
+			}
+			fmt.Printf("%s %s %s %s %s\n", e.Principal, status, verify.KeyType(e.Key), e.Fingerprint(), e.FirstSeen.UTC().Format(time.RFC3339))
+		}
+		os.Exit(0)
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Println("Usage: ssh-sign tofu accept [--file=<store>] <principal> [<fingerprint>]")
+		os.Exit(1)
+	}
+	var accepted *tofu.Entry
+	_, err = tofu.Update(path, func(s *tofu.Store) (err error) {
+		accepted, err = s.Accept(fs.Arg(0), fs.Arg(1))
+		return err
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Accepted %s key %s for %s\n", verify.KeyType(accepted.Key), accepted.Fingerprint(), accepted.Principal)
+	os.Exit(0)
+}
+
+// Returns the path of the trust-on-first-use store: the given file, the
+// file in the ssh-sign.tofuFile git config key, or the default path.
+func tofuPath(repo gitobj.Repo, path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	path, err := repo.ConfigPath("ssh-sign.tofuFile")
+	if err != nil || path != "" {
+		return path, err
+	}
+	return tofu.DefaultPath()
+}
+
+// Load the trust-on-first-use store, if ssh-sign.tofu is enabled in the git
+// config. Returns nil if it is not.
+func loadTOFU(repo gitobj.Repo) (*tofu.Store, error) {
+	enabled, err := repo.ConfigBool("ssh-sign.tofu")
+	if err != nil || !enabled {
+		return nil, err
+	}
+	path, err := tofuPath(repo, "")
+	if err != nil {
+		return nil, err
+	}
+	return tofu.Load(path)
+}
+
+// Record the key of a good signature, checked by check-novalidate because no
+// allowed signer matched it, for the committer or tagger of the payload, in
+// the store file. The first key seen for them is trusted from then on. A
+// different key fails the result, with a warning that cannot be missed.
+//
+// An email that is a principal in gpg.ssh.allowedSignersFile is not trusted
+// on first use: its keys are those of the file, and any other key, e.g. of
+// someone committing as them, fails the result.
+func trustOnFirstUse(repo gitobj.Repo, store *tofu.Store, result *verify.Result, armored, payload []byte) error {
+	email := gitobj.SignerEmail(payload)
+	if email == "" {
+		return nil
+	}
+	sig, err := verify.Decode(armored)
+	if err != nil {
+		return err
+	}
+
+	allowedSignersFile, err := repo.ConfigPath("gpg.ssh.allowedSignersFile")
+	if err != nil {
+		return err
+	}
+	if allowedSignersFile != "" {
+		allowedSigners, err := verify.GetAllowedSigners(allowedSignersFile)
+		if err != nil {
+			return err
+		}
+		if len(verify.MatchPrincipals(allowedSigners, email)) > 0 {
+			err := fmt.Errorf("%w: %s in %s", tofu.ErrAllowedSigner, email, allowedSignersFile)
+			fmt.Fprintf(os.Stderr, "ssh-sign: not trusting %s key %s for %s on first use, as they are in %s with another key\n", result.KeyType, result.Fingerprint, email, allowedSignersFile)
+			result.Status = verify.StatusNotAllowed
+			result.Reason = err.Error()
+			result.Err = err
+			return nil
+		}
+	}
+
+	// The store is read again under its lock, as other runs may have
+	// changed it since it was loaded.
+	var recorded bool
+	_, updateErr := tofu.Update(store.Path, func(s *tofu.Store) error {
+		recorded, err = s.See(email, sig.PublicKey, time.Now())
+		if errors.Is(err, tofu.ErrKeyChanged) {
+			// Record the changed key, and fail the result below.
+			return nil
+		}
+		return err
+	})
+	if updateErr != nil {
+		return updateErr
+	}
+
+	if errors.Is(err, tofu.ErrKeyChanged) {
+		fmt.Fprintln(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+		fmt.Fprintf(os.Stderr, "@  WARNING: THE SIGNING KEY OF %s HAS CHANGED!\n", email)
+		fmt.Fprintln(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+		fmt.Fprintln(os.Stderr, "Someone could be signing as them with a key of their own.")
+		fmt.Fprintf(os.Stderr, "If the new key is expected, run: ssh-sign tofu accept %s %s\n", email, result.Fingerprint)
+		result.Status = verify.StatusNotAllowed
+		result.Reason = err.Error()
+		result.Err = err
+	} else if recorded {
+		fmt.Fprintf(os.Stderr, "ssh-sign: trusting %s key %s for %s on first use\n", result.KeyType, result.Fingerprint, email)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+// Verifies the signature of a commit or tag, as `git verify-commit` and
+// `git verify-tag` do, without git writing the payload and signature to
+// temporary files and calling this program. The object is read from the
+// repository in the current directory and its signature checked against
+// the allowed signers in gpg.ssh.allowedSignersFile, and the revocation
+// file in gpg.ssh.revocationFile, if set. The signatures of the tags a merge
+// commit merges, which git does not verify, are verified and reported, too.
+//
+//	ssh-sign verify-commit [--format=json] <rev>
+//	ssh-sign verify-tag [--format=json] <tag>
+func verifyObject(command string, args []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	format := fs.String("format", "text", "Output format, 'text' or 'json'")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		arg := "rev"
+		if command == "verify-tag" {
+			arg = "tag"
+		}
+		fmt.Printf("Usage: ssh-sign %s [--format=json] <%s>\n", command, arg)
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Printf("Unsupported format, '%s'; try 'text' or 'json'.\n", *format)
+		os.Exit(1)
+	}
+
+	repo := gitobj.Repo{}
+	opts, err := gitVerifyOptions(repo, "")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var obj *gitobj.Object
+	if command == "verify-commit" {
+		obj, err = repo.ReadCommit(fs.Arg(0))
+	} else {
+		obj, err = repo.ReadTag(fs.Arg(0))
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	printVerification(gitobj.Verify(obj, opts), *format)
+}
+
+// The options git verifies signatures with in the repository, i.e. the
+// allowed signers and revoked keys from its configuration. If an allowed
+// signers file is given, it is used instead of the configured one.
+func gitVerifyOptions(repo gitobj.Repo, allowedSignersFile string) (verify.Options, error) {
+	var err error
+	if allowedSignersFile == "" {
+		allowedSignersFile, err = repo.ConfigPath("gpg.ssh.allowedSignersFile")
+		if err != nil {
+			return verify.Options{}, err
+		}
+	}
+	if allowedSignersFile == "" {
+		return verify.Options{}, errors.New("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	allowedSigners, err := verify.GetAllowedSigners(allowedSignersFile)
+	if err != nil {
+		return verify.Options{}, err
+	}
+
+	revocationFile, err := repo.ConfigPath("gpg.ssh.revocationFile")
+	if err != nil {
+		return verify.Options{}, err
+	}
+	revocations, err := loadRevocations(revocationFile)
+	if err != nil {
+		return verify.Options{}, err
+	}
+
+	return verify.Options{AllowedSigners: allowedSigners, Revocations: revocations}, nil
+}
+
+// Print the verdict for the object, followed by the result as ssh-keygen
+// would print it, and the same for each merged tag, or the verification as
+// JSON. Exit with a non-zero status unless the signature is good and the
+// signatures of merged tags, if any, verify.
+func printVerification(v *gitobj.Verification, format string) {
+	if format == "json" {
+		b, err := json.Marshal(v)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(b))
+	} else {
+		fmt.Printf("%s %s: %s\n", v.Type, v.Object, v.Status)
+		fmt.Println(v.Text())
+		for _, mt := range v.MergeTags {
+			fmt.Printf("%s %s: %s\n", mt.Type, mt.Object, mt.Status)
+			fmt.Println(mt.Text())
+		}
+	}
+
+	if v.Status != verify.StatusGood || v.MergeTagFailed() {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
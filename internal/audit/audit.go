@@ -0,0 +1,248 @@
+package audit
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/policy"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+/*
+	An audit verifies the signature of every commit in a revision range, of
+	the tags merged by them, and of every annotated tag that points to one of
+	them. Objects are read from
+	a single git process and verified concurrently by a pool of workers,
+	sharing the parsed allowed signers, so that large histories can be
+	audited quickly.
+
+	Each object is classified by the status of its verification. The audit
+	fails if more objects than the threshold have one of the statuses that
+	the audit fails on.
+*/
+
+// The statuses an audit fails on by default: every signature that does not
+// verify. Unsigned objects and objects with OpenPGP or X.509 signatures are
+// only counted.
+var DefaultFailOn = []verify.Status{
+	verify.StatusBadSignature,
+	verify.StatusUnknownSigner,
+	verify.StatusExpired,
+	verify.StatusRevoked,
+	verify.StatusNotAllowed,
+	verify.StatusUnauthorized,
+	verify.StatusError,
+}
+
+// Every status, in the order they are reported in.
+var Statuses = []verify.Status{
+	verify.StatusGood,
+	verify.StatusUnsigned,
+	verify.StatusNonSSH,
+	verify.StatusBadSignature,
+	verify.StatusUnknownSigner,
+	verify.StatusExpired,
+	verify.StatusRevoked,
+	verify.StatusNotAllowed,
+	verify.StatusUnauthorized,
+	verify.StatusError,
+}
+
+type Options struct {
+	// The allowed signers and revoked keys to verify with. Signatures are
+	// verified at the time of each object unless a time is given.
+	Verify verify.Options
+	// The number of objects verified concurrently. Defaults to the number
+	// of CPUs.
+	Workers int
+	// The statuses that count as failures. Defaults to DefaultFailOn.
+	FailOn []verify.Status
+	// The number of failures tolerated before the audit fails.
+	Threshold int
+	// If set, good signatures by signers the policy does not authorize for
+	// the paths a commit changes, or for a tag, are reported as
+	// unauthorized.
+	Policy *policy.Policy
+}
+
+type Report struct {
+	Range     []string               `json:"range"`
+	Total     int                    `json:"total"`
+	Counts    map[verify.Status]int  `json:"counts"`
+	FailOn    []verify.Status        `json:"fail_on"`
+	Failures  int                    `json:"failures"`
+	Threshold int                    `json:"threshold"`
+	Passed    bool                   `json:"passed"`
+	Objects   []*gitobj.Verification `json:"objects"`
+}
+
+// Returns true if the status of the verification counts as a failure.
+func (r *Report) IsFailure(v *gitobj.Verification) bool {
+	for _, s := range r.FailOn {
+		if v.Status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Audit the commits in the revision range, e.g. "v1.0.0..main", and the
+// annotated tags that point to them. The objects are reported in the order
+// of `git rev-list`, each merge commit followed by the tags it merges, and
+// then the annotated tags.
+func Run(repo gitobj.Repo, revs []string, opts Options) (*Report, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.FailOn == nil {
+		opts.FailOn = DefaultFailOn
+	}
+
+	ids, err := objectsInRange(repo, revs)
+	if err != nil {
+		return nil, err
+	}
+
+	verifications, err := verifyObjects(repo, ids, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := flatten(verifications)
+
+	report := &Report{
+		Range:     revs,
+		Total:     len(results),
+		Counts:    map[verify.Status]int{},
+		FailOn:    opts.FailOn,
+		Threshold: opts.Threshold,
+		Objects:   results,
+	}
+	for _, v := range results {
+		report.Counts[v.Status]++
+		if report.IsFailure(v) {
+			report.Failures++
+		}
+	}
+	report.Passed = report.Failures <= report.Threshold
+	return report, nil
+}
+
+// List the commits in the range, followed by the annotated tags that point
+// to them.
+func objectsInRange(repo gitobj.Repo, revs []string) ([]string, error) {
+	commits, err := repo.RevList(revs...)
+	if err != nil {
+		return nil, err
+	}
+
+	inRange := make(map[string]bool, len(commits))
+	for _, c := range commits {
+		inRange[c] = true
+	}
+
+	tags, err := repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+	ids := commits
+	for _, t := range tags {
+		if inRange[t.Target] {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids, nil
+}
+
+type job struct {
+	index int
+	obj   *gitobj.Object
+}
+
+// Read the objects in a batch and verify them with a pool of workers. The
+// results are in the same order as the objects.
+func verifyObjects(repo gitobj.Repo, ids []string, opts Options) ([]*gitobj.Verification, error) {
+	// The paths commits change are listed by a single git process shared by
+	// the workers, and only if the policy has rules for paths.
+	var diffTree *gitobj.DiffTree
+	var lister policy.PathLister
+	if opts.Policy != nil && opts.Policy.HasPathRules() {
+		dt, err := repo.NewDiffTree()
+		if err != nil {
+			return nil, err
+		}
+		diffTree, lister = dt, dt
+	}
+
+	batch, err := repo.NewBatch()
+	if err != nil {
+		if diffTree != nil {
+			diffTree.Close()
+		}
+		return nil, err
+	}
+
+	results := make([]*gitobj.Verification, len(ids))
+	jobs := make(chan job, opts.Workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				v := gitobj.Verify(j.obj, opts.Verify)
+				if opts.Policy != nil {
+					opts.Policy.ApplyObject(lister, j.obj, v, "")
+					for i, mt := range v.MergeTags {
+						opts.Policy.ApplyObject(lister, j.obj.MergeTags[i], mt, "")
+					}
+				}
+				results[j.index] = v
+			}
+		}()
+	}
+
+	var readErr error
+	for i, id := range ids {
+		obj, err := batch.ReadObject(id)
+		if err != nil {
+			readErr = err
+			break
+		}
+		jobs <- job{index: i, obj: obj}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := batch.Close(); err != nil && readErr == nil {
+		readErr = fmt.Errorf("git cat-file: %w", err)
+	}
+	if diffTree != nil {
+		if err := diffTree.Close(); err != nil && readErr == nil {
+			readErr = fmt.Errorf("git diff-tree: %w", err)
+		}
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	return results, nil
+}
+
+// List the verifications, each followed by those of the tags merged by the
+// commit, which are reported as objects of their own.
+func flatten(verifications []*gitobj.Verification) []*gitobj.Verification {
+	results := make([]*gitobj.Verification, 0, len(verifications))
+	for _, v := range verifications {
+		if len(v.MergeTags) == 0 {
+			results = append(results, v)
+			continue
+		}
+		commit := *v
+		commit.MergeTags = nil
+		results = append(results, &commit)
+		results = append(results, v.MergeTags...)
+	}
+	return results
+}
@@ -0,0 +1,388 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/policy"
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+	"golang.org/x/crypto/ssh"
+)
+
+// A repository with a main branch that each commit is added to.
+type testRepo struct {
+	*testutil.Repo
+	head string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	return &testRepo{Repo: testutil.NewRepo(t)}
+}
+
+// Commit on top of the previous commit and update main to point to it.
+func (r *testRepo) commit(signer ssh.AlgorithmSigner, message string) string {
+	return r.merge(signer, message)
+}
+
+// Commit on top of the previous commit, merging the raw tags, and update
+// main to point to it.
+func (r *testRepo) merge(signer ssh.AlgorithmSigner, message string, tags ...string) string {
+	r.head = r.Commit(signer, r.Tree, r.head, message, tags...)
+	r.UpdateRef("refs/heads/main", r.head)
+	return r.head
+}
+
+// Tag the commit with an annotated tag.
+func (r *testRepo) tag(signer ssh.AlgorithmSigner, name, commit string) string {
+	id := r.Tag(signer, name, commit)
+	r.UpdateRef("refs/tags/"+name, id)
+	return id
+}
+
+func TestRun(t *testing.T) {
+	alice := testutil.NewSigner(t)
+	mallory := testutil.NewSigner(t)
+	repo := newTestRepo(t)
+
+	first := repo.commit(alice, "first")
+	second := repo.commit(nil, "second")
+	third := repo.commit(mallory, "third")
+	v1 := repo.tag(alice, "v1", first)
+	repo.tag(nil, "v1-unsigned", first)
+	fourth := repo.commit(alice, "fourth")
+
+	opts := verify.Options{
+		AllowedSigners: []verify.AllowedSigner{{
+			Email:      "alice@example.com",
+			Principals: []string{"alice@example.com"},
+			PublicKey:  testutil.AuthorizedKey(alice.PublicKey()),
+			Line:       1,
+		}},
+	}
+
+	report, err := Run(gitobj.Repo{Dir: repo.Dir}, []string{"main"}, Options{Verify: opts, Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		id     string
+		status verify.Status
+	}{
+		{fourth, verify.StatusGood},
+		{third, verify.StatusUnknownSigner},
+		{second, verify.StatusUnsigned},
+		{first, verify.StatusGood},
+		{v1, verify.StatusGood},
+	}
+	// The unsigned tag is reported too, but its position among the tags is
+	// up to git.
+	if report.Total != len(expected)+1 {
+		t.Fatalf("Run returned %d objects, expected %d", report.Total, len(expected)+1)
+	}
+	for i, e := range expected {
+		if v := report.Objects[i]; v.Object != e.id || v.Status != e.status {
+			t.Errorf("Run returned %s %s for object %d, expected %s %s", v.Object, v.Status, i, e.id, e.status)
+		}
+	}
+
+	counts := map[verify.Status]int{verify.StatusGood: 3, verify.StatusUnsigned: 2, verify.StatusUnknownSigner: 1}
+	for s, n := range counts {
+		if report.Counts[s] != n {
+			t.Errorf("Run counted %d %s objects, expected %d", report.Counts[s], s, n)
+		}
+	}
+	if report.Failures != 1 || report.Passed {
+		t.Errorf("Run returned %d failures, passed: %v", report.Failures, report.Passed)
+	}
+
+	// The audit passes when the failure is tolerated, and fails on unsigned
+	// objects when asked to.
+	for _, tt := range []struct {
+		name     string
+		failOn   []verify.Status
+		thresh   int
+		failures int
+		passed   bool
+	}{
+		{"tolerated", nil, 1, 1, true},
+		{"unsigned", []verify.Status{verify.StatusUnsigned}, 1, 2, false},
+		{"nothing", []verify.Status{}, 0, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Run(gitobj.Repo{Dir: repo.Dir}, []string{"main"}, Options{Verify: opts, FailOn: tt.failOn, Threshold: tt.thresh})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Failures != tt.failures || report.Passed != tt.passed {
+				t.Errorf("Run returned %d failures, passed: %v; expected %d, %v", report.Failures, report.Passed, tt.failures, tt.passed)
+			}
+		})
+	}
+
+	// Only the commits in the range, and no tags, are audited.
+	report, err = Run(gitobj.Repo{Dir: repo.Dir}, []string{first + "..main"}, Options{Verify: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 3 {
+		t.Errorf("Run returned %d objects for a range, expected 3", report.Total)
+	}
+
+	// A good signature by a signer the policy does not authorize is reported
+	// as unauthorized.
+	p, err := policy.Parse(strings.NewReader("ref refs/tags/v* *@release.example.com\n"), "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = Run(gitobj.Repo{Dir: repo.Dir}, []string{"main"}, Options{Verify: opts, Policy: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := report.Objects[4]; v.Object != v1 || v.Status != verify.StatusUnauthorized || v.Principal != "alice@example.com" {
+		t.Errorf("Run returned %s %s by %s for a tag, expected %s unauthorized", v.Object, v.Status, v.Principal, v1)
+	}
+	if report.Counts[verify.StatusGood] != 2 || report.Counts[verify.StatusUnauthorized] != 1 || report.Failures != 2 {
+		t.Errorf("Run returned counts %v and %d failures with a policy", report.Counts, report.Failures)
+	}
+
+	if _, err := Run(gitobj.Repo{Dir: repo.Dir}, []string{"missing"}, Options{Verify: opts}); err == nil {
+		t.Error("Run returned no error for a missing revision")
+	}
+}
+
+func TestRunPathPolicy(t *testing.T) {
+	alice := testutil.NewSigner(t)
+	bob := testutil.NewSigner(t)
+	repo := newTestRepo(t)
+
+	docs := repo.TreeWith("docs", "index.md")
+	deploy := repo.TreeWith("deploy", "app.yaml")
+	first := repo.Commit(alice, docs, "", "docs by alice")
+	second := repo.Commit(bob, deploy, first, "deploy by bob")
+	third := repo.Commit(bob, deploy, second, "nothing by bob")
+	repo.UpdateRef("refs/heads/main", third)
+
+	opts := verify.Options{AllowedSigners: []verify.AllowedSigner{
+		{Email: "alice@example.com", Principals: []string{"alice@example.com"}, PublicKey: testutil.AuthorizedKey(alice.PublicKey()), Line: 1},
+		{Email: "bob@example.com", Principals: []string{"bob@example.com"}, PublicKey: testutil.AuthorizedKey(bob.PublicKey()), Line: 2},
+	}}
+	p, err := policy.Parse(strings.NewReader("* *@example.com\ndeploy/ alice@example.com\n"), "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The paths of each commit are listed by the workers as they verify it.
+	report, err := Run(gitobj.Repo{Dir: repo.Dir}, []string{"main"}, Options{Verify: opts, Policy: p, Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range []struct {
+		object string
+		status verify.Status
+	}{
+		{third, verify.StatusGood},
+		{second, verify.StatusUnauthorized},
+		{first, verify.StatusGood},
+	} {
+		if v := report.Objects[i]; v.Object != e.object || v.Status != e.status {
+			t.Errorf("Run returned %s %s, expected %s %s", v.Object, v.Status, e.object, e.status)
+		}
+	}
+}
+
+func TestRunMergeTags(t *testing.T) {
+	alice := testutil.NewSigner(t)
+	mallory := testutil.NewSigner(t)
+	repo := newTestRepo(t)
+
+	first := repo.commit(alice, "first")
+	goodTag := repo.RawTag(alice, "v1", first)
+	badTag := repo.RawTag(mallory, "v2", first)
+	merge := repo.merge(alice, "merge", goodTag, badTag)
+
+	opts := verify.Options{
+		AllowedSigners: []verify.AllowedSigner{{
+			Email:      "alice@example.com",
+			Principals: []string{"alice@example.com"},
+			PublicKey:  testutil.AuthorizedKey(alice.PublicKey()),
+			Line:       1,
+		}},
+	}
+	report, err := Run(gitobj.Repo{Dir: repo.Dir}, []string{"main"}, Options{Verify: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The merged tags follow the merge commit.
+	expected := []struct {
+		objType string
+		status  verify.Status
+	}{
+		{gitobj.TypeCommit, verify.StatusGood},
+		{gitobj.TypeMergeTag, verify.StatusGood},
+		{gitobj.TypeMergeTag, verify.StatusUnknownSigner},
+		{gitobj.TypeCommit, verify.StatusGood},
+	}
+	if report.Total != len(expected) {
+		t.Fatalf("Run returned %d objects, expected %d", report.Total, len(expected))
+	}
+	for i, e := range expected {
+		if v := report.Objects[i]; v.Type != e.objType || v.Status != e.status || len(v.MergeTags) != 0 {
+			t.Errorf("Run returned %s %s for object %d, expected %s %s", v.Type, v.Status, i, e.objType, e.status)
+		}
+	}
+	if v := report.Objects[2]; v.Commit != merge {
+		t.Errorf("Run returned mergetag in commit %s, expected %s", v.Commit, merge)
+	}
+	if report.Failures != 1 || report.Passed {
+		t.Errorf("Run returned %d failures, passed: %v", report.Failures, report.Passed)
+	}
+}
+
+func testReport() *Report {
+	return &Report{
+		Range:     []string{"HEAD"},
+		Total:     4,
+		Counts:    map[verify.Status]int{verify.StatusGood: 1, verify.StatusUnsigned: 1, verify.StatusRevoked: 1, verify.StatusUnknownSigner: 1},
+		FailOn:    DefaultFailOn,
+		Failures:  2,
+		Threshold: 0,
+		Passed:    false,
+		Objects: []*gitobj.Verification{
+			{Object: "aaa", Type: "commit", Result: &verify.Result{Status: verify.StatusGood, Principal: "alice@example.com"}},
+			{Object: "bbb", Type: "commit", Result: &verify.Result{Status: verify.StatusUnsigned, Reason: "no signature found"}},
+			{Object: "ccc", Type: "tag", Result: &verify.Result{Status: verify.StatusRevoked, Reason: "key is revoked: SHA256:abc"}},
+			{Object: "ddd", Type: "mergetag", Commit: "aaa", Result: &verify.Result{Status: verify.StatusUnknownSigner, Reason: "no principal matched"}},
+		},
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var b bytes.Buffer
+	if err := testReport().WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Audited 4 objects in HEAD
+  good             1
+  unsigned         1
+  unknown-signer   1
+  revoked          1
+
+tag ccc: revoked: key is revoked: SHA256:abc
+mergetag ddd in commit aaa: unknown-signer: no principal matched
+
+FAILED: 2 failures (bad-signature, unknown-signer, expired, revoked, not-allowed, unauthorized, error), threshold 0
+`
+	if b.String() != expected {
+		t.Errorf("WriteText returned\n%s\nexpected\n%s", b.String(), expected)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := testReport().WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Total   int
+		Passed  bool
+		Counts  map[string]int
+		Objects []struct {
+			Object    string
+			Status    string
+			Principal string
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total != 4 || decoded.Passed || decoded.Counts["revoked"] != 1 {
+		t.Errorf("WriteJSON returned %s", b.String())
+	}
+	if len(decoded.Objects) != 4 || decoded.Objects[0].Principal != "alice@example.com" || decoded.Objects[2].Status != "revoked" {
+		t.Errorf("WriteJSON returned objects %+v", decoded.Objects)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var b bytes.Buffer
+	if err := testReport().WriteJUnit(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Skipped  int `xml:"skipped,attr"`
+		Suites   []struct {
+			Name  string `xml:"name,attr"`
+			Cases []struct {
+				ClassName string `xml:"classname,attr"`
+				Failure   *struct {
+					Message string `xml:"message,attr"`
+				} `xml:"failure"`
+				Skipped *struct {
+					Type string `xml:"type,attr"`
+				} `xml:"skipped"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Tests != 4 || decoded.Failures != 2 || decoded.Skipped != 1 {
+		t.Errorf("WriteJUnit returned %d tests, %d failures and %d skipped", decoded.Tests, decoded.Failures, decoded.Skipped)
+	}
+
+	if decoded.Suites[0].Name != "ssh-sign audit HEAD" {
+		t.Errorf("WriteJUnit returned test suite %q", decoded.Suites[0].Name)
+	}
+	cases := decoded.Suites[0].Cases
+	if cases[0].Failure != nil || cases[0].Skipped != nil {
+		t.Error("WriteJUnit reported a good signature as failed or skipped")
+	}
+	if cases[1].Skipped == nil || cases[1].Skipped.Type != "unsigned" {
+		t.Error("WriteJUnit did not report an unsigned commit as skipped")
+	}
+	if cases[2].Failure == nil || cases[2].Failure.Message != "key is revoked: SHA256:abc" || cases[2].ClassName != "tag" {
+		t.Errorf("WriteJUnit returned %+v for a revoked key", cases[2])
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var b bytes.Buffer
+	if err := testReport().WriteSARIF(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Runs []struct {
+			Results []struct {
+				RuleID string
+				Level  string
+			}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// The good commit is not a result, and the unsigned commit, which the
+	// audit does not fail on, is a warning.
+	var results []string
+	for _, r := range decoded.Runs[0].Results {
+		results = append(results, r.RuleID+":"+r.Level)
+	}
+	expected := "unsigned:warning revoked:error unknown-signer:error"
+	if strings.Join(results, " ") != expected {
+		t.Errorf("WriteSARIF returned results %v, expected %s", results, expected)
+	}
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/report"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+// Write a summary of the report: the number of objects with each status,
+// followed by each failure and the verdict.
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Audited %d objects in %s\n", r.Total, strings.Join(r.Range, " "))
+	for _, s := range Statuses {
+		if n := r.Counts[s]; n > 0 {
+			fmt.Fprintf(&b, "  %-16s %d\n", s, n)
+		}
+	}
+
+	if r.Failures > 0 {
+		b.WriteString("\n")
+		for _, v := range r.Objects {
+			if r.IsFailure(v) {
+				fmt.Fprintf(&b, "%s: %s: %s\n", report.Describe(v), v.Status, v.Reason)
+			}
+		}
+	}
+
+	verdict := "PASSED"
+	if !r.Passed {
+		verdict = "FAILED"
+	}
+	fmt.Fprintf(&b, "\n%s: %d failures (%s), threshold %d\n", verdict, r.Failures, joinStatuses(r.FailOn), r.Threshold)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Write the report as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Write the report as JUnit XML, with a test case for each object. Failures
+// are reported as failed test cases, and objects with other statuses than
+// good, e.g. unsigned commits, as skipped test cases.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	return report.WriteJUnit(w, r.run())
+}
+
+// Write the report as a SARIF 2.1.0 log, with a result for each object that
+// is not good, for code scanning dashboards. Failures are errors, and other
+// objects, e.g. unsigned commits, warnings.
+func (r *Report) WriteSARIF(w io.Writer) error {
+	return report.WriteSARIF(w, r.run())
+}
+
+// The objects of the report, and whether each is a failure.
+func (r *Report) run() report.Run {
+	run := report.Run{Name: "ssh-sign audit", Target: strings.Join(r.Range, " ")}
+	for _, v := range r.Objects {
+		run.Results = append(run.Results, report.Result{Verification: v, Failed: r.IsFailure(v)})
+	}
+	return run
+}
+
+func joinStatuses(statuses []verify.Status) string {
+	s := make([]string, len(statuses))
+	for i, status := range statuses {
+		s[i] = string(status)
+	}
+	return strings.Join(s, ", ")
+}
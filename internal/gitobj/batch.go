@@ -0,0 +1,91 @@
+package gitobj
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Reads objects from a single `git cat-file --batch` process, which is much
+// faster than running git for each object when reading many of them. A Batch
+// must not be used concurrently.
+type Batch struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// Start reading objects from the repository in a batch. The batch must be
+// closed once done.
+func (r Repo) NewBatch() (*Batch, error) {
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = r.Dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &Batch{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// Read and parse the commit or tag with the given name.
+func (b *Batch) ReadObject(id string) (*Object, error) {
+	objType, raw, err := b.read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch objType {
+	case TypeCommit:
+		return ParseCommit(id, raw)
+	case TypeTag:
+		return ParseTag(id, raw)
+	default:
+		return nil, fmt.Errorf("%s: unsupported object type '%s'", id, objType)
+	}
+}
+
+// Read the raw object with the given name. git prints a header line of
+// "<name> <type> <size>", followed by the object and a newline.
+func (b *Batch) read(id string) (string, []byte, error) {
+	if _, err := fmt.Fprintln(b.stdin, id); err != nil {
+		return "", nil, err
+	}
+
+	header, err := b.stdout.ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return "", nil, fmt.Errorf("%s: no such object", id)
+	}
+	if len(fields) != 3 {
+		return "", nil, fmt.Errorf("%s: unexpected output from git cat-file: %s", id, strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: invalid object size: %s", id, fields[2])
+	}
+
+	raw := make([]byte, size+1)
+	if _, err := io.ReadFull(b.stdout, raw); err != nil {
+		return "", nil, err
+	}
+	return fields[1], raw[:size], nil
+}
+
+// Stop the git process.
+func (b *Batch) Close() error {
+	b.stdin.Close()
+	return b.cmd.Wait()
+}
@@ -0,0 +1,87 @@
+package gitobj
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Lists the paths commits change from a single `git diff-tree --stdin`
+// process, rather than running git for each commit. A DiffTree may be used
+// concurrently; the commits are listed one at a time.
+type DiffTree struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// git echoes lines that are not object names, so the line written after each
+// commit marks the end of its paths. No path starts with a '/'.
+const diffTreeEnd = "/\n"
+
+// Start listing the paths commits change in the repository. The DiffTree
+// must be closed once done.
+func (r Repo) NewDiffTree() (*DiffTree, error) {
+	cmd := exec.Command("git", "diff-tree", "--stdin", "-r", "-z", "--name-only", "--no-commit-id", "--no-renames", "--root")
+	cmd.Dir = r.Dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &DiffTree{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// List the paths of the files the commit changes, compared to its first
+// parent, or every file of a root commit.
+func (d *DiffTree) ChangedPaths(commit *Object) ([]string, error) {
+	if commit.Type != TypeCommit {
+		return nil, fmt.Errorf("%s: not a commit", commit.ID)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// A commit followed by another is compared to that one, rather than to
+	// all of its parents, which for a merge lists no paths.
+	line := commit.ID
+	if parent, ok := commit.Header("parent"); ok {
+		line += " " + parent
+	}
+	if _, err := fmt.Fprintf(d.stdin, "%s\n%s", line, diffTreeEnd); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for {
+		end, err := d.stdout.Peek(len(diffTreeEnd))
+		if err != nil {
+			return nil, fmt.Errorf("%s: git diff-tree: %w", commit.ID, err)
+		}
+		if string(end) == diffTreeEnd {
+			_, err := d.stdout.Discard(len(diffTreeEnd))
+			return paths, err
+		}
+		p, err := d.stdout.ReadString(0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: git diff-tree: %w", commit.ID, err)
+		}
+		paths = append(paths, strings.TrimSuffix(p, "\x00"))
+	}
+}
+
+// Stop the git process.
+func (d *DiffTree) Close() error {
+	d.stdin.Close()
+	return d.cmd.Wait()
+}
@@ -0,0 +1,392 @@
+package gitobj
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Commits and tags are signed over the raw object, minus the signature.
+
+	A commit carries its signature in a "gpgsig" header, whose value spans
+	several lines, each continuation line being prefixed with a space:
+
+		tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904
+		author A U Thor <author@example.com> 1700000000 +0000
+		committer A U Thor <author@example.com> 1700000000 +0000
+		gpgsig -----BEGIN SSH SIGNATURE-----
+		 U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgRC9KsG/bwosCNg7ukP3WmuGV6e
+		 ...
+		 -----END SSH SIGNATURE-----
+
+		Commit message
+
+	A tag carries its signature after the tag message instead. In both cases
+	the signed payload is rebuilt exactly as git does, by removing the
+	signature from the raw object.
+
+	A merge of a tag, e.g. `git merge v1.0.0`, copies the whole tag object,
+	including its signature, into a "mergetag" header of the merge commit,
+	in the same way. The tag is signed on its own, while the commit signature
+	covers the header, too.
+*/
+
+const (
+	TypeCommit   = "commit"
+	TypeTag      = "tag"
+	TypePushCert = "push-cert"
+)
+
+// Signature formats, as named by gpg.format.
+const (
+	FormatSSH     = "ssh"
+	FormatOpenPGP = "openpgp"
+	FormatX509    = "x509"
+)
+
+// The first line of the armored signatures of each format.
+var signaturePrefixes = []struct {
+	prefix string
+	format string
+}{
+	{"-----BEGIN SSH SIGNATURE-----", FormatSSH},
+	{"-----BEGIN PGP SIGNATURE-----", FormatOpenPGP},
+	{"-----BEGIN PGP MESSAGE-----", FormatOpenPGP},
+	{"-----BEGIN SIGNED MESSAGE-----", FormatX509},
+}
+
+// A header of a commit or tag. The value of a multi-line header has its
+// continuation lines joined with newlines, without the leading space.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// A commit or tag, split into the payload that was signed and its signature.
+type Object struct {
+	ID      string
+	Type    string
+	Headers []Header
+	// The data the signature was made over.
+	Payload []byte
+	// The armored signature, or nil if the object is unsigned.
+	Signature []byte
+	// The committer time of a commit, or the tagger time of a tag, at which
+	// git verifies the signature.
+	Time time.Time
+	// The tags in the mergetag headers of a merge commit.
+	MergeTags []*Object
+}
+
+// Returns the value of the first header with the given name.
+func (o *Object) Header(name string) (string, bool) {
+	for _, h := range o.Headers {
+		if h.Name == name {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Returns the format of the signature, e.g. "ssh" or "openpgp", or an empty
+// string if the object is unsigned or the format is unknown.
+func (o *Object) SignatureFormat() string {
+	return signatureFormat(o.Signature)
+}
+
+func signatureFormat(sig []byte) string {
+	for _, p := range signaturePrefixes {
+		if bytes.HasPrefix(sig, []byte(p.prefix)) {
+			return p.format
+		}
+	}
+	return ""
+}
+
+// Parse a raw commit object, as printed by `git cat-file commit`. The
+// signature is taken from the gpgsig header, or the gpgsig-sha256 header in
+// repositories using SHA-256 object names, as told by the length of the id.
+// A commit may carry both, signing each of its forms, and neither is part of
+// the payload.
+func ParseCommit(id string, raw []byte) (*Object, error) {
+	headers, err := parseHeaders(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Object{ID: id, Type: TypeCommit, Headers: headers}
+	obj.Payload = removeHeaders(raw, "gpgsig", "gpgsig-sha256")
+	name := "gpgsig"
+	if len(id) == sha256.Size*2 {
+		name = "gpgsig-sha256"
+	}
+	if sig, ok := obj.Header(name); ok {
+		obj.Signature = []byte(sig + "\n")
+	}
+
+	if committer, ok := obj.Header("committer"); ok {
+		if obj.Time, err = identTime(committer); err != nil {
+			return nil, fmt.Errorf("invalid committer: %w", err)
+		}
+	}
+
+	for _, h := range headers {
+		if h.Name != "mergetag" {
+			continue
+		}
+		raw := []byte(h.Value + "\n")
+		tag, err := ParseTag(hashObject(TypeTag, raw, len(id) == sha256.Size*2), raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mergetag: %w", err)
+		}
+		obj.MergeTags = append(obj.MergeTags, tag)
+	}
+	return obj, nil
+}
+
+// Parse a raw tag object, as printed by `git cat-file tag`. The signature is
+// the trailing block of the tag message, starting at the last line that
+// begins a signature.
+func ParseTag(id string, raw []byte) (*Object, error) {
+	headers, err := parseHeaders(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Object{ID: id, Type: TypeTag, Headers: headers, Payload: raw}
+	if i := signatureStart(raw); i >= 0 {
+		obj.Payload = raw[:i]
+		obj.Signature = raw[i:]
+	}
+
+	if tagger, ok := obj.Header("tagger"); ok {
+		if obj.Time, err = identTime(tagger); err != nil {
+			return nil, fmt.Errorf("invalid tagger: %w", err)
+		}
+	}
+	return obj, nil
+}
+
+// Returns the name of a raw object of the given type, as `git hash-object`
+// would compute it, using SHA-256 in repositories with SHA-256 object names
+// and SHA-1 otherwise.
+func hashObject(objType string, raw []byte, useSHA256 bool) string {
+	var h hash.Hash
+	if useSHA256 {
+		h = sha256.New()
+	} else {
+		h = sha1.New()
+	}
+	fmt.Fprintf(h, "%s %d\x00", objType, len(raw))
+	h.Write(raw)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Parse a push certificate, as stored by git for a signed push, e.g.:
+//
+//	certificate version 0.1
+//	pusher SHA256:gzanxu0EbBLCHysMq7dYALt9//p7uEDv8MqoDBN4XO0 1700000000 +0000
+//	pushee git@example.com:repo.git
+//	nonce 1700000000-4d0c1589dad1456ba49eeff914422de711e489e0
+//
+//	<old> <new> <ref>
+//	-----BEGIN SSH SIGNATURE-----
+//	...
+//
+// The signature follows the updates, as in a tag. The pusher is the
+// fingerprint of the key for SSH signatures.
+func ParsePushCert(id string, raw []byte) (*Object, error) {
+	headers, err := parseHeaders(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Object{ID: id, Type: TypePushCert, Headers: headers, Payload: raw}
+	if version, _ := obj.Header("certificate"); version != "version 0.1" {
+		return nil, fmt.Errorf("unsupported push certificate '%s'", version)
+	}
+	if i := signatureStart(raw); i >= 0 {
+		obj.Payload = raw[:i]
+		obj.Signature = raw[i:]
+	}
+
+	pusher, ok := obj.Header("pusher")
+	if !ok {
+		return nil, errors.New("push certificate without a pusher")
+	}
+	if _, obj.Time, err = parsePusher(pusher); err != nil {
+		return nil, fmt.Errorf("invalid pusher: %w", err)
+	}
+	return obj, nil
+}
+
+// Returns the identity of the pusher of a push certificate, e.g. the
+// fingerprint of the key it is signed with.
+func (o *Object) Pusher() string {
+	pusher, _ := o.Header("pusher")
+	identity, _, _ := parsePusher(pusher)
+	return identity
+}
+
+// Returns the lines of the message of the object, i.e. those following the
+// headers, up to the signature.
+func (o *Object) Message() []string {
+	_, message, _ := bytes.Cut(o.Payload, []byte("\n\n"))
+	if len(message) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(message), "\n"), "\n")
+}
+
+// Parse the headers of a raw object, which end at the first empty line.
+func parseHeaders(raw []byte) ([]Header, error) {
+	var headers []Header
+	for len(raw) > 0 {
+		line := raw
+		if i := bytes.IndexByte(raw, '\n'); i >= 0 {
+			line, raw = raw[:i], raw[i+1:]
+		} else {
+			raw = nil
+		}
+		if len(line) == 0 {
+			break
+		}
+
+		if line[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, errors.New("invalid object: continuation line without a header")
+			}
+			headers[len(headers)-1].Value += "\n" + string(line[1:])
+			continue
+		}
+
+		name, value, _ := strings.Cut(string(line), " ")
+		headers = append(headers, Header{Name: name, Value: value})
+	}
+	return headers, nil
+}
+
+// Remove the headers with any of the given names, and their continuation
+// lines, from a raw object.
+func removeHeaders(raw []byte, names ...string) []byte {
+	out := make([]byte, 0, len(raw))
+	removing := false
+	for len(raw) > 0 {
+		line := raw
+		if i := bytes.IndexByte(raw, '\n'); i >= 0 {
+			line, raw = raw[:i+1], raw[i+1:]
+		} else {
+			raw = nil
+		}
+
+		// The headers end at the first empty line; the rest of the object is
+		// the message.
+		if len(line) == 0 || line[0] == '\n' {
+			out = append(out, line...)
+			out = append(out, raw...)
+			break
+		}
+
+		if removing && line[0] == ' ' {
+			continue
+		}
+		removing = false
+		for _, name := range names {
+			if bytes.HasPrefix(line, []byte(name+" ")) {
+				removing = true
+				break
+			}
+		}
+		if removing {
+			continue
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+// Returns the offset of the last line of the object that begins a signature,
+// or -1 if there is none.
+func signatureStart(raw []byte) int {
+	start := -1
+	for offset := 0; offset < len(raw); {
+		if signatureFormat(raw[offset:]) != "" {
+			start = offset
+		}
+		i := bytes.IndexByte(raw[offset:], '\n')
+		if i < 0 {
+			break
+		}
+		offset += i + 1
+	}
+	return start
+}
+
+// Parse the time from an identity, e.g.
+// "A U Thor <author@example.com> 1700000000 +0000".
+func identTime(ident string) (time.Time, error) {
+	i := strings.LastIndexByte(ident, '>')
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("missing email in '%s'", ident)
+	}
+
+	fields := strings.Fields(ident[i+1:])
+	if len(fields) == 0 {
+		return time.Time{}, fmt.Errorf("missing timestamp in '%s'", ident)
+	}
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp in '%s'", ident)
+	}
+	return time.Unix(ts, 0), nil
+}
+
+// Returns the email of an identity, e.g. "author@example.com" for
+// "A U Thor <author@example.com> 1700000000 +0000", or an empty string if
+// it has none.
+func IdentEmail(ident string) string {
+	start, end := strings.LastIndexByte(ident, '<'), strings.LastIndexByte(ident, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	return ident[start+1 : end]
+}
+
+// Returns the email of the committer of a commit, or the tagger of a tag,
+// from the payload git signs and verifies, or an empty string if it has
+// neither, e.g. for a push certificate.
+func SignerEmail(payload []byte) string {
+	headers, err := parseHeaders(payload)
+	if err != nil {
+		return ""
+	}
+	for _, h := range headers {
+		if h.Name == "committer" || h.Name == "tagger" {
+			return IdentEmail(h.Value)
+		}
+	}
+	return ""
+}
+
+// Parse the pusher of a push certificate into the identity of the pusher,
+// i.e. a key fingerprint or a "Name <email>" ident, and the time of the
+// push, from the "<timestamp> <timezone>" at its end.
+func parsePusher(pusher string) (string, time.Time, error) {
+	fields := strings.Fields(pusher)
+	if len(fields) < 3 {
+		return "", time.Time{}, fmt.Errorf("missing timestamp in '%s'", pusher)
+	}
+	ts, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid timestamp in '%s'", pusher)
+	}
+	return strings.Join(fields[:len(fields)-2], " "), time.Unix(ts, 0), nil
+}
@@ -0,0 +1,375 @@
+package gitobj
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSignature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgRC9KsG/bwosCNg7ukP3WmuGV6e
+0bfX9P+rbutQhDwRjR0xIE
+-----END SSH SIGNATURE-----
+`
+
+	testCommitPayload = `tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904
+parent 6f5b234bd20adae38746511b61dd92f81a35e503
+author A U Thor <author@example.com> 1700000000 +0100
+committer C O Mitter <committer@example.com> 1700000060 +0100
+
+Add a feature
+
+With a longer description.
+`
+
+	testTagPayload = `object 6f5b234bd20adae38746511b61dd92f81a35e503
+type commit
+tag v1.0.0
+tagger T A Gger <tagger@example.com> 1700000120 +0000
+
+Release 1.0.0
+`
+)
+
+// Insert the signature into the commit as a gpgsig header, as git does,
+// after the other headers.
+func withGpgsig(commit, sig string) string {
+	header := "gpgsig " + strings.ReplaceAll(strings.TrimSuffix(sig, "\n"), "\n", "\n ") + "\n"
+	i := strings.Index(commit, "\n\n")
+	return commit[:i+1] + header + commit[i+1:]
+}
+
+// Insert the raw tags into the commit as mergetag headers, as git does for
+// a merge of the tags, after the other headers.
+func withMergetags(commit string, tags ...string) string {
+	var headers string
+	for _, tag := range tags {
+		headers += "mergetag " + strings.ReplaceAll(strings.TrimSuffix(tag, "\n"), "\n", "\n ") + "\n"
+	}
+	i := strings.Index(commit, "\n\n")
+	return commit[:i+1] + headers + commit[i+1:]
+}
+
+func TestParseCommit(t *testing.T) {
+	raw := withGpgsig(testCommitPayload, testSignature)
+
+	obj, err := ParseCommit("abc", []byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.ID != "abc" || obj.Type != TypeCommit {
+		t.Errorf("ParseCommit returned %s %s", obj.Type, obj.ID)
+	}
+	if string(obj.Payload) != testCommitPayload {
+		t.Errorf("ParseCommit returned payload\n%s\nexpected\n%s", obj.Payload, testCommitPayload)
+	}
+	if string(obj.Signature) != testSignature {
+		t.Errorf("ParseCommit returned signature\n%s\nexpected\n%s", obj.Signature, testSignature)
+	}
+	if obj.SignatureFormat() != FormatSSH {
+		t.Errorf("SignatureFormat returned %s, expected %s", obj.SignatureFormat(), FormatSSH)
+	}
+	if !obj.Time.Equal(time.Unix(1700000060, 0)) {
+		t.Errorf("ParseCommit returned time %v, expected the committer time", obj.Time)
+	}
+	if parent, _ := obj.Header("parent"); parent != "6f5b234bd20adae38746511b61dd92f81a35e503" {
+		t.Errorf("Header returned parent %s", parent)
+	}
+}
+
+func TestParseCommitMergeTags(t *testing.T) {
+	signedTag := testTagPayload + testSignature
+	// Empty lines of the tag are kept as lines with a single space.
+	unsignedTag := strings.Replace(testTagPayload, "Release 1.0.0\n", "Release 1.0.1\n\nWith fixes.\n", 1)
+	payload := withMergetags(testCommitPayload, signedTag, unsignedTag)
+	if !strings.Contains(payload, "\n \n With fixes.\n") {
+		t.Fatalf("withMergetags returned\n%s", payload)
+	}
+
+	obj, err := ParseCommit(strings.Repeat("a", 40), []byte(withGpgsig(payload, testSignature)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The mergetag headers are part of the payload of the commit.
+	if string(obj.Payload) != payload {
+		t.Errorf("ParseCommit returned payload\n%s\nexpected\n%s", obj.Payload, payload)
+	}
+	if len(obj.MergeTags) != 2 {
+		t.Fatalf("ParseCommit returned %d mergetags, expected 2", len(obj.MergeTags))
+	}
+
+	tag := obj.MergeTags[0]
+	if tag.Type != TypeTag || len(tag.ID) != 40 {
+		t.Errorf("ParseCommit returned mergetag %s %s", tag.Type, tag.ID)
+	}
+	if string(tag.Payload) != testTagPayload || string(tag.Signature) != testSignature {
+		t.Errorf("ParseCommit returned mergetag payload\n%s\nand signature\n%s", tag.Payload, tag.Signature)
+	}
+	if !tag.Time.Equal(time.Unix(1700000120, 0)) {
+		t.Errorf("ParseCommit returned mergetag time %v, expected the tagger time", tag.Time)
+	}
+	if tag := obj.MergeTags[1]; string(tag.Payload) != unsignedTag || tag.Signature != nil {
+		t.Errorf("ParseCommit returned mergetag payload\n%s\nexpected\n%s", tag.Payload, unsignedTag)
+	}
+
+	// Mergetags in repositories with SHA-256 object names have SHA-256 names.
+	obj, err = ParseCommit(strings.Repeat("a", 64), []byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obj.MergeTags[0].ID) != 64 {
+		t.Errorf("ParseCommit returned mergetag %s, expected a SHA-256 name", obj.MergeTags[0].ID)
+	}
+}
+
+func TestParseCommitBothSignatures(t *testing.T) {
+	sha256Signature := strings.Replace(testSignature, "0bfX9P", "1cgY0Q", 1)
+	signatures := "gpgsig " + strings.ReplaceAll(strings.TrimSuffix(testSignature, "\n"), "\n", "\n ") + "\n" +
+		"gpgsig-sha256 " + strings.ReplaceAll(strings.TrimSuffix(sha256Signature, "\n"), "\n", "\n ") + "\n"
+	headersEnd := strings.Index(testCommitPayload, "\n\n") + 1
+	// A header after the signatures is signed, too.
+	withEncoding := testCommitPayload[:headersEnd] + "encoding ISO-8859-1\n" + testCommitPayload[headersEnd:]
+
+	for _, tt := range []struct {
+		name    string
+		id      string
+		raw     string
+		payload string
+		sig     string
+	}{
+		{"sha1", strings.Repeat("a", 40), testCommitPayload[:headersEnd] + signatures + testCommitPayload[headersEnd:], testCommitPayload, testSignature},
+		{"sha256", strings.Repeat("a", 64), testCommitPayload[:headersEnd] + signatures + testCommitPayload[headersEnd:], testCommitPayload, sha256Signature},
+		{"header after the signatures", strings.Repeat("a", 40), testCommitPayload[:headersEnd] + signatures + withEncoding[headersEnd:], withEncoding, testSignature},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := ParseCommit(tt.id, []byte(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(obj.Signature) != tt.sig {
+				t.Errorf("ParseCommit returned signature\n%s\nexpected\n%s", obj.Signature, tt.sig)
+			}
+			// Neither signature is part of the payload.
+			if string(obj.Payload) != tt.payload {
+				t.Errorf("ParseCommit returned payload\n%s\nexpected\n%s", obj.Payload, tt.payload)
+			}
+		})
+	}
+}
+
+func TestParseCommitUnsigned(t *testing.T) {
+	obj, err := ParseCommit("abc", []byte(testCommitPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Signature != nil || obj.SignatureFormat() != "" {
+		t.Errorf("ParseCommit returned signature %q for an unsigned commit", obj.Signature)
+	}
+	if string(obj.Payload) != testCommitPayload {
+		t.Error("ParseCommit modified the payload of an unsigned commit")
+	}
+}
+
+func TestParseCommitSignatureInMessage(t *testing.T) {
+	// A signature in the commit message is not a signature of the commit.
+	raw := testCommitPayload + "\n" + testSignature
+	obj, err := ParseCommit("abc", []byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Signature != nil {
+		t.Error("ParseCommit returned a signature from the commit message")
+	}
+
+	// Nor is a line starting with gpgsig in the message.
+	raw = withGpgsig(testCommitPayload+"gpgsig in the message\n", testSignature)
+	obj, err = ParseCommit("abc", []byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(obj.Payload), "gpgsig in the message\n") {
+		t.Errorf("ParseCommit removed a line from the commit message:\n%s", obj.Payload)
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		sig    string
+		format string
+	}{
+		{
+			name:   "ssh",
+			sig:    testSignature,
+			format: FormatSSH,
+		},
+		{
+			name:   "openpgp",
+			sig:    "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----\n",
+			format: FormatOpenPGP,
+		},
+		{
+			name:   "x509",
+			sig:    "-----BEGIN SIGNED MESSAGE-----\nMIAGCSqGSIb3DQEHAqCAMIACAQEx\n-----END SIGNED MESSAGE-----\n",
+			format: FormatX509,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := ParseTag("abc", []byte(testTagPayload+tt.sig))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(obj.Payload) != testTagPayload {
+				t.Errorf("ParseTag returned payload\n%s\nexpected\n%s", obj.Payload, testTagPayload)
+			}
+			if string(obj.Signature) != tt.sig {
+				t.Errorf("ParseTag returned signature\n%s\nexpected\n%s", obj.Signature, tt.sig)
+			}
+			if obj.SignatureFormat() != tt.format {
+				t.Errorf("SignatureFormat returned %s, expected %s", obj.SignatureFormat(), tt.format)
+			}
+			if !obj.Time.Equal(time.Unix(1700000120, 0)) {
+				t.Errorf("ParseTag returned time %v, expected the tagger time", obj.Time)
+			}
+		})
+	}
+}
+
+func TestParseTagLastSignature(t *testing.T) {
+	// A message that quotes a signature is signed over, quote included.
+	payload := testTagPayload + "\n" + testSignature + "\nEnd of quote\n"
+	obj, err := ParseTag("abc", []byte(payload+testSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(obj.Payload, []byte(payload)) {
+		t.Errorf("ParseTag returned payload\n%s\nexpected\n%s", obj.Payload, payload)
+	}
+}
+
+const testPushCertPayload = `certificate version 0.1
+pusher SHA256:gzanxu0EbBLCHysMq7dYALt9//p7uEDv8MqoDBN4XO0  1700000180 +0000
+pushee git@example.com:repo.git
+nonce 1700000170-4d0c1589dad1456ba49eeff914422de711e489e0
+
+0000000000000000000000000000000000000000 6f5b234bd20adae38746511b61dd92f81a35e503 refs/heads/main
+6f5b234bd20adae38746511b61dd92f81a35e503 0000000000000000000000000000000000000000 refs/heads/old
+`
+
+func TestParsePushCert(t *testing.T) {
+	obj, err := ParsePushCert("abc", []byte(testPushCertPayload+testSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Type != TypePushCert || string(obj.Payload) != testPushCertPayload || string(obj.Signature) != testSignature {
+		t.Errorf("ParsePushCert returned %s with payload\n%s\nand signature\n%s", obj.Type, obj.Payload, obj.Signature)
+	}
+	if pusher := obj.Pusher(); pusher != "SHA256:gzanxu0EbBLCHysMq7dYALt9//p7uEDv8MqoDBN4XO0" {
+		t.Errorf("Pusher returned %q", pusher)
+	}
+	if !obj.Time.Equal(time.Unix(1700000180, 0)) {
+		t.Errorf("ParsePushCert returned time %v, expected the push time", obj.Time)
+	}
+	if nonce, _ := obj.Header("nonce"); nonce != "1700000170-4d0c1589dad1456ba49eeff914422de711e489e0" {
+		t.Errorf("Header returned nonce %s", nonce)
+	}
+	if lines := obj.Message(); len(lines) != 2 || !strings.HasSuffix(lines[1], " refs/heads/old") {
+		t.Errorf("Message returned %q", lines)
+	}
+
+	// An ident, as used for OpenPGP signatures.
+	obj, err = ParsePushCert("abc", []byte(strings.Replace(testPushCertPayload, "SHA256:gzanxu0EbBLCHysMq7dYALt9//p7uEDv8MqoDBN4XO0 ", "A U Thor <author@example.com>", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pusher := obj.Pusher(); pusher != "A U Thor <author@example.com>" || obj.Signature != nil {
+		t.Errorf("Pusher returned %q", pusher)
+	}
+
+	for _, raw := range []string{
+		strings.Replace(testPushCertPayload, "version 0.1", "version 0.2", 1),
+		strings.Replace(testPushCertPayload, "  1700000180 +0000", "", 1),
+		strings.Replace(testPushCertPayload, "pusher", "pushed", 1),
+	} {
+		if _, err := ParsePushCert("abc", []byte(raw)); err == nil {
+			t.Errorf("ParsePushCert returned no error for\n%s", raw)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders([]byte("a 1\nb 2\n 3\n\nc 4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Header{{"a", "1"}, {"b", "2\n3"}}
+	if len(headers) != len(expected) {
+		t.Fatalf("parseHeaders returned %v, expected %v", headers, expected)
+	}
+	for i := range expected {
+		if headers[i] != expected[i] {
+			t.Errorf("parseHeaders returned %v, expected %v", headers[i], expected[i])
+		}
+	}
+
+	if _, err := parseHeaders([]byte(" 1\n")); err == nil {
+		t.Error("parseHeaders returned no error for a continuation line without a header")
+	}
+}
+
+func TestIdentTime(t *testing.T) {
+	for _, tt := range []struct {
+		ident    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{
+			ident:    "A U Thor <author@example.com> 1700000000 +0000",
+			expected: time.Unix(1700000000, 0),
+		},
+		{
+			ident:    "A <U> Thor <author@example.com> 1700000000 -0700",
+			expected: time.Unix(1700000000, 0),
+		},
+		{
+			ident:   "A U Thor <author@example.com>",
+			wantErr: true,
+		},
+		{
+			ident:   "A U Thor author@example.com 1700000000 +0000",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.ident, func(t *testing.T) {
+			ts, err := identTime(tt.ident)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("identTime returned error %v, expected error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !ts.Equal(tt.expected) {
+				t.Errorf("identTime returned %v, expected %v", ts, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSignerEmail(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		payload  string
+		expected string
+	}{
+		{name: "commit", payload: testCommitPayload, expected: "committer@example.com"},
+		{name: "tag", payload: testTagPayload, expected: "tagger@example.com"},
+		{name: "push certificate", payload: testPushCertPayload, expected: ""},
+		{name: "no email", payload: "tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904\ncommitter C O Mitter 1700000060 +0100\n\nmsg\n", expected: ""},
+		{name: "invalid", payload: " 1\n", expected: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if email := SignerEmail([]byte(tt.payload)); email != tt.expected {
+				t.Errorf("SignerEmail returned %q, expected %q", email, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package gitobj
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// A git repository, accessed by running git in its directory. An empty Dir
+// is the current directory.
+type Repo struct {
+	Dir string
+}
+
+// Run git with the given arguments and return its standard output. If git
+// fails, its standard error is returned as the error.
+func (r Repo) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// Returns the value of a git config key that holds a path, with a leading
+// "~/" expanded. An empty string is returned if the key is not set.
+func (r Repo) ConfigPath(key string) (string, error) {
+	return r.config("path", key)
+}
+
+// Returns the value of a boolean git config key, or false if it is not set.
+func (r Repo) ConfigBool(key string) (bool, error) {
+	value, err := r.config("bool", key)
+	return value == "true", err
+}
+
+// Returns the value of a git config key, normalized to the given type.
+func (r Repo) config(typ, key string) (string, error) {
+	out, err := r.git("config", "--type="+typ, "--get", key)
+	if err != nil {
+		var exitErr *exec.ExitError
+		// git config exits with status 1 if the key is not set.
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Resolve a revision to the full name of the object of the given type, e.g.
+// a branch name to the commit it points to.
+func (r Repo) Resolve(rev, objType string) (string, error) {
+	out, err := r.git("rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{"+objType+"}")
+	if err != nil {
+		return "", fmt.Errorf("%s: no such %s", rev, objType)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Read and parse the commit the revision resolves to.
+func (r Repo) ReadCommit(rev string) (*Object, error) {
+	id, raw, err := r.read(rev, TypeCommit)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCommit(id, raw)
+}
+
+// Read and parse the annotated tag the revision, e.g. a tag name, resolves
+// to.
+func (r Repo) ReadTag(rev string) (*Object, error) {
+	id, raw, err := r.read(rev, TypeTag)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTag(id, raw)
+}
+
+func (r Repo) read(rev, objType string) (string, []byte, error) {
+	id, err := r.Resolve(rev, objType)
+	if err != nil {
+		return "", nil, err
+	}
+	raw, err := r.git("cat-file", objType, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, raw, nil
+}
+
+// Read and parse the push certificate stored in the blob with the given
+// name, as passed to hooks in GIT_PUSH_CERT.
+func (r Repo) ReadPushCert(id string) (*Object, error) {
+	if !IsObjectName(id) {
+		return nil, fmt.Errorf("invalid object name '%s'", id)
+	}
+	raw, err := r.git("cat-file", "blob", id)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePushCert(id, raw)
+}
+
+// List the commits in the revision range, e.g. "main..feature", newest
+// first, as `git rev-list` does.
+func (r Repo) RevList(revs ...string) ([]string, error) {
+	args := append([]string{"rev-list", "--end-of-options"}, revs...)
+	out, err := r.git(args...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// An annotated tag and the object it points to.
+type TagRef struct {
+	Name   string
+	ID     string
+	Target string
+}
+
+// List the annotated tags in the repository. Lightweight tags, which are
+// plain references to commits, are not included.
+func (r Repo) Tags() ([]TagRef, error) {
+	out, err := r.git("for-each-ref", "--format=%(objecttype) %(objectname) %(*objectname) %(refname:short)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []TagRef
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 || fields[0] != TypeTag {
+			continue
+		}
+		tags = append(tags, TagRef{Name: fields[3], ID: fields[1], Target: fields[2]})
+	}
+	return tags, nil
+}
+
+// Returns the type of the object with the given name, e.g. "commit".
+func (r Repo) ObjectType(id string) (string, error) {
+	out, err := r.git("cat-file", "-t", id)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// List the commits reachable from the given commit that are not reachable
+// from any reference, i.e. those introduced by a push that has not updated
+// any reference yet.
+func (r Repo) NewCommits(id string) ([]string, error) {
+	if !IsObjectName(id) {
+		return nil, fmt.Errorf("invalid object name '%s'", id)
+	}
+	out, err := r.git("rev-list", id, "--not", "--all")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// Returns true if the string is a full SHA-1 or SHA-256 object name.
+func IsObjectName(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the object name is all zeros, which git uses for a
+// reference that does not exist, e.g. before it is created.
+func IsZero(id string) bool {
+	return IsObjectName(id) && strings.Trim(id, "0") == ""
+}
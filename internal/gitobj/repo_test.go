@@ -0,0 +1,308 @@
+package gitobj
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Create a repository in a temporary directory, skipping the test if git is
+// not installed.
+func newTestRepo(t *testing.T) Repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := Repo{Dir: t.TempDir()}
+	if _, err := repo.git("init", "--quiet"); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// Write the raw object to the repository, returning its name.
+func writeObject(t *testing.T, repo Repo, objType, raw string) string {
+	t.Helper()
+	cmd := exec.Command("git", "hash-object", "-t", objType, "-w", "--stdin")
+	cmd.Dir = repo.Dir
+	cmd.Stdin = strings.NewReader(raw)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestRepoReadObjects(t *testing.T) {
+	repo := newTestRepo(t)
+
+	tree := writeObject(t, repo, "tree", "")
+	commitPayload := strings.Replace(testCommitPayload, "4b825dc642cb6eb9a060e54bf8d69288fbe4904", tree, 1)
+	commitPayload = strings.Replace(commitPayload, "parent 6f5b234bd20adae38746511b61dd92f81a35e503\n", "", 1)
+	commitID := writeObject(t, repo, TypeCommit, withGpgsig(commitPayload, testSignature))
+
+	tagPayload := strings.Replace(testTagPayload, "6f5b234bd20adae38746511b61dd92f81a35e503", commitID, 1)
+	tagID := writeObject(t, repo, TypeTag, tagPayload+testSignature)
+	if _, err := repo.git("update-ref", "refs/tags/v1.0.0", tagID); err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := repo.ReadCommit(commitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.ID != commitID || string(commit.Payload) != commitPayload || string(commit.Signature) != testSignature {
+		t.Errorf("ReadCommit returned %s with payload\n%s\nand signature\n%s", commit.ID, commit.Payload, commit.Signature)
+	}
+
+	// The tag peels to the commit.
+	if peeled, err := repo.ReadCommit("v1.0.0"); err != nil || peeled.ID != commitID {
+		t.Errorf("ReadCommit returned %v, %v for the tag", peeled, err)
+	}
+
+	tag, err := repo.ReadTag("v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.ID != tagID || string(tag.Payload) != tagPayload || string(tag.Signature) != testSignature {
+		t.Errorf("ReadTag returned %s with payload\n%s\nand signature\n%s", tag.ID, tag.Payload, tag.Signature)
+	}
+
+	if _, err := repo.ReadTag(commitID); err == nil {
+		t.Error("ReadTag returned no error for a commit")
+	}
+	if _, err := repo.ReadCommit("missing"); err == nil {
+		t.Error("ReadCommit returned no error for a missing revision")
+	}
+}
+
+func TestRepoConfigPath(t *testing.T) {
+	repo := newTestRepo(t)
+
+	if path, err := repo.ConfigPath("gpg.ssh.allowedSignersFile"); err != nil || path != "" {
+		t.Errorf("ConfigPath returned %q, %v for an unset key", path, err)
+	}
+
+	if _, err := repo.git("config", "gpg.ssh.allowedSignersFile", "/etc/ssh/allowed_signers"); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := repo.ConfigPath("gpg.ssh.allowedSignersFile"); err != nil || path != "/etc/ssh/allowed_signers" {
+		t.Errorf("ConfigPath returned %q, %v", path, err)
+	}
+}
+
+func TestRepoConfigBool(t *testing.T) {
+	repo := newTestRepo(t)
+
+	if on, err := repo.ConfigBool("ssh-sign.tofu"); err != nil || on {
+		t.Errorf("ConfigBool returned %v, %v for an unset key", on, err)
+	}
+
+	for value, want := range map[string]bool{"true": true, "yes": true, "1": true, "false": false, "off": false} {
+		if _, err := repo.git("config", "ssh-sign.tofu", value); err != nil {
+			t.Fatal(err)
+		}
+		if on, err := repo.ConfigBool("ssh-sign.tofu"); err != nil || on != want {
+			t.Errorf("ConfigBool returned %v, %v for %q", on, err, value)
+		}
+	}
+}
+
+func TestBatchReadObject(t *testing.T) {
+	repo := newTestRepo(t)
+
+	tree := writeObject(t, repo, "tree", "")
+	commitPayload := strings.Replace(testCommitPayload, "4b825dc642cb6eb9a060e54bf8d69288fbe4904", tree, 1)
+	commitPayload = strings.Replace(commitPayload, "parent 6f5b234bd20adae38746511b61dd92f81a35e503\n", "", 1)
+	commitID := writeObject(t, repo, TypeCommit, withGpgsig(commitPayload, testSignature))
+	tagPayload := strings.Replace(testTagPayload, "6f5b234bd20adae38746511b61dd92f81a35e503", commitID, 1)
+	tagID := writeObject(t, repo, TypeTag, tagPayload+testSignature)
+
+	batch, err := repo.NewBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Close()
+
+	// Objects can be read repeatedly, in any order.
+	for _, id := range []string{commitID, tagID, commitID} {
+		obj, err := batch.ReadObject(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.ID != id || string(obj.Signature) != testSignature {
+			t.Errorf("ReadObject returned %s %s with signature\n%s", obj.Type, obj.ID, obj.Signature)
+		}
+	}
+
+	if _, err := batch.ReadObject(tree); err == nil {
+		t.Error("ReadObject returned no error for a tree")
+	}
+	if _, err := batch.ReadObject("0000000000000000000000000000000000000000"); err == nil {
+		t.Error("ReadObject returned no error for a missing object")
+	}
+	if obj, err := batch.ReadObject(tagID); err != nil || obj.Type != TypeTag {
+		t.Errorf("ReadObject returned %v, %v after an error", obj, err)
+	}
+}
+
+func TestRepoRevListAndTags(t *testing.T) {
+	repo := newTestRepo(t)
+
+	tree := writeObject(t, repo, "tree", "")
+	first := writeObject(t, repo, TypeCommit, "tree "+tree+"\nauthor A <a@example.com> 1 +0000\ncommitter A <a@example.com> 1 +0000\n\nfirst\n")
+	second := writeObject(t, repo, TypeCommit, "tree "+tree+"\nparent "+first+"\nauthor A <a@example.com> 2 +0000\ncommitter A <a@example.com> 2 +0000\n\nsecond\n")
+	tag := writeObject(t, repo, TypeTag, "object "+first+"\ntype commit\ntag v1\ntagger A <a@example.com> 3 +0000\n\nv1\n")
+	for ref, id := range map[string]string{"refs/heads/main": second, "refs/tags/v1": tag, "refs/tags/light": second} {
+		if _, err := repo.git("update-ref", ref, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commits, err := repo.RevList("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(commits, " ") != second+" "+first {
+		t.Errorf("RevList returned %v, expected [%s %s]", commits, second, first)
+	}
+	if commits, err := repo.RevList("v1..main"); err != nil || len(commits) != 1 || commits[0] != second {
+		t.Errorf("RevList returned %v, %v for a range", commits, err)
+	}
+
+	tags, err := repo.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != (TagRef{Name: "v1", ID: tag, Target: first}) {
+		t.Errorf("Tags returned %v, expected only the annotated tag", tags)
+	}
+}
+
+func TestRepoNewCommitsAndObjectType(t *testing.T) {
+	repo := newTestRepo(t)
+
+	tree := writeObject(t, repo, "tree", "")
+	first := writeObject(t, repo, TypeCommit, "tree "+tree+"\nauthor A <a@example.com> 1 +0000\ncommitter A <a@example.com> 1 +0000\n\nfirst\n")
+	second := writeObject(t, repo, TypeCommit, "tree "+tree+"\nparent "+first+"\nauthor A <a@example.com> 2 +0000\ncommitter A <a@example.com> 2 +0000\n\nsecond\n")
+	tag := writeObject(t, repo, TypeTag, "object "+second+"\ntype commit\ntag v1\ntagger A <a@example.com> 3 +0000\n\nv1\n")
+	if _, err := repo.git("update-ref", "refs/heads/main", first); err != nil {
+		t.Fatal(err)
+	}
+
+	if commits, err := repo.NewCommits(tag); err != nil || len(commits) != 1 || commits[0] != second {
+		t.Errorf("NewCommits returned %v, %v, expected [%s]", commits, err, second)
+	}
+	if _, err := repo.NewCommits("main"); err == nil {
+		t.Error("NewCommits returned no error for a reference name")
+	}
+
+	for id, expected := range map[string]string{first: TypeCommit, tag: TypeTag, tree: "tree"} {
+		if objType, err := repo.ObjectType(id); err != nil || objType != expected {
+			t.Errorf("ObjectType returned %s, %v for %s, expected %s", objType, err, id, expected)
+		}
+	}
+}
+
+func TestIsObjectName(t *testing.T) {
+	tests := []struct {
+		s      string
+		name   bool
+		isZero bool
+	}{
+		{strings.Repeat("0", 40), true, true},
+		{strings.Repeat("0", 64), true, true},
+		{"4b825dc642cb6eb9a060e54bf8d69288fbee4904", true, false},
+		{"4B825DC642CB6EB9A060E54BF8D69288FBEE4904", false, false},
+		{"4b825dc", false, false},
+		{"main", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		if IsObjectName(tt.s) != tt.name || IsZero(tt.s) != tt.isZero {
+			t.Errorf("IsObjectName, IsZero returned %v, %v for %q", IsObjectName(tt.s), IsZero(tt.s), tt.s)
+		}
+	}
+}
+
+func TestDiffTreeChangedPaths(t *testing.T) {
+	repo := newTestRepo(t)
+
+	// Build the trees of the commits in the index of the repository.
+	writeTree := func(files map[string]string) string {
+		for path, content := range files {
+			blob := writeObject(t, repo, "blob", content)
+			if _, err := repo.git("update-index", "--add", "--cacheinfo", "100644,"+blob+","+path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out, err := repo.git("write-tree")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	root := writeObject(t, repo, TypeCommit, "tree "+writeTree(map[string]string{"README.md": "a", "deploy/app.yaml": "a"})+"\nauthor A <a@example.com> 1 +0000\ncommitter A <a@example.com> 1 +0000\n\nroot\n")
+	child := writeObject(t, repo, TypeCommit, "tree "+writeTree(map[string]string{"deploy/app.yaml": "b", "src/main.go": "b"})+"\nparent "+root+"\nauthor A <a@example.com> 2 +0000\ncommitter A <a@example.com> 2 +0000\n\nchild\n")
+
+	empty := writeObject(t, repo, TypeCommit, "tree "+writeTree(nil)+"\nparent "+child+"\nauthor A <a@example.com> 3 +0000\ncommitter A <a@example.com> 3 +0000\n\nempty\n")
+	odd := writeObject(t, repo, TypeCommit, "tree "+writeTree(map[string]string{"odd\n/name": "c"})+"\nparent "+empty+"\nauthor A <a@example.com> 4 +0000\ncommitter A <a@example.com> 4 +0000\n\nodd\n")
+	// A merge is compared to its first parent.
+	merge := writeObject(t, repo, TypeCommit, "tree "+writeTree(nil)+"\nparent "+root+"\nparent "+odd+"\nauthor A <a@example.com> 5 +0000\ncommitter A <a@example.com> 5 +0000\n\nmerge\n")
+
+	dt, err := repo.NewDiffTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The commits are listed one after another by the same process.
+	for _, tt := range []struct {
+		id       string
+		expected string
+	}{
+		{root, "README.md deploy/app.yaml"},
+		{child, "deploy/app.yaml src/main.go"},
+		{empty, ""},
+		{odd, "odd\n/name"},
+		{merge, "deploy/app.yaml odd\n/name src/main.go"},
+		{root, "README.md deploy/app.yaml"},
+	} {
+		commit, err := repo.ReadCommit(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths, err := dt.ChangedPaths(commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(paths, " ") != tt.expected {
+			t.Errorf("ChangedPaths returned %q for %s, expected %q", paths, tt.id, tt.expected)
+		}
+	}
+
+	if _, err := dt.ChangedPaths(&Object{ID: root, Type: TypeTag}); err == nil {
+		t.Error("ChangedPaths returned no error for a tag")
+	}
+	if err := dt.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRepoReadMergeTag(t *testing.T) {
+	repo := newTestRepo(t)
+
+	tree := writeObject(t, repo, "tree", "")
+	first := writeObject(t, repo, TypeCommit, "tree "+tree+"\nauthor A <a@example.com> 1 +0000\ncommitter A <a@example.com> 1 +0000\n\nfirst\n")
+	tag := "object " + first + "\ntype commit\ntag v1\ntagger A <a@example.com> 2 +0000\n\nv1\n\nFirst release.\n"
+	tagID := writeObject(t, repo, TypeTag, tag)
+	merge := writeObject(t, repo, TypeCommit, withMergetags("tree "+tree+"\nparent "+first+"\nauthor A <a@example.com> 3 +0000\ncommitter A <a@example.com> 3 +0000\n\nMerge v1\n", tag))
+
+	obj, err := repo.ReadCommit(merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obj.MergeTags) != 1 || obj.MergeTags[0].ID != tagID {
+		t.Errorf("ReadCommit returned mergetags %+v, expected %s", obj.MergeTags, tagID)
+	}
+}
@@ -0,0 +1,99 @@
+package gitobj
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+var (
+	ErrUnsigned        = errors.New("no signature found")
+	ErrNonSSHSignature = errors.New("not an SSH signature")
+)
+
+// The type of the verification of a tag in a mergetag header of a commit.
+const TypeMergeTag = "mergetag"
+
+// The result of verifying the signature of a commit or tag.
+type Verification struct {
+	Object string `json:"object"`
+	Type   string `json:"type"`
+	// The merge commit the tag of a mergetag verification is in.
+	Commit string `json:"commit,omitempty"`
+	*verify.Result
+	// The verifications of the tags merged by a commit.
+	MergeTags []*Verification `json:"merge_tags,omitempty"`
+}
+
+// Verify the signature of the object in the "git" namespace, and of the
+// tags it merges, if any. Unless a time is given in the options, each
+// signature is verified at the time of its object, as git does.
+func Verify(obj *Object, opts verify.Options) *Verification {
+	v := verifyObject(obj, opts)
+	for _, tag := range obj.MergeTags {
+		mt := verifyObject(tag, opts)
+		mt.Type = TypeMergeTag
+		mt.Commit = obj.ID
+		v.MergeTags = append(v.MergeTags, mt)
+	}
+	return v
+}
+
+// Returns true if the signature of any tag merged by the commit fails to
+// verify. Unsigned tags, and tags with OpenPGP or X.509 signatures, are not
+// failures.
+func (v *Verification) MergeTagFailed() bool {
+	for _, mt := range v.MergeTags {
+		switch mt.Status {
+		case verify.StatusGood, verify.StatusUnsigned, verify.StatusNonSSH:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// Mark the verification as failed with the status and error, e.g. when a
+// good signature is rejected by a check after verification. The result is
+// replaced by a copy, keeping the details of the signature.
+func (v *Verification) Fail(status verify.Status, err error) {
+	result := *v.Result
+	result.Status = status
+	result.Reason = err.Error()
+	result.Err = err
+	v.Result = &result
+}
+
+func verifyObject(obj *Object, opts verify.Options) *Verification {
+	v := &Verification{Object: obj.ID, Type: obj.Type}
+	opts.Namespace = sign.DefaultNamespace
+	if opts.Time.IsZero() {
+		opts.Time = obj.Time
+	}
+
+	switch obj.SignatureFormat() {
+	case FormatSSH:
+		v.Result = verify.Verify(obj.Signature, bytes.NewReader(obj.Payload), opts)
+	case "":
+		if obj.Signature == nil {
+			v.Result = failed(verify.StatusUnsigned, ErrUnsigned)
+		} else {
+			v.Result = failed(verify.StatusError, errors.New("unknown signature format"))
+		}
+	default:
+		v.Result = failed(verify.StatusNonSSH, fmt.Errorf("%w: %s", ErrNonSSHSignature, obj.SignatureFormat()))
+	}
+	return v
+}
+
+func failed(status verify.Status, err error) *verify.Result {
+	return &verify.Result{
+		Status:    status,
+		Namespace: sign.DefaultNamespace,
+		Reason:    err.Error(),
+		Err:       err,
+	}
+}
@@ -0,0 +1,178 @@
+package gitobj
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+	"golang.org/x/crypto/ssh"
+)
+
+// An allowed signer for the key of the signer.
+func allowedSigner(principal string, signer ssh.Signer, opts verify.SignerOptions) verify.AllowedSigner {
+	return verify.AllowedSigner{
+		Email:      principal,
+		Principals: []string{principal},
+		PublicKey:  testutil.AuthorizedKey(signer.PublicKey()),
+		Options:    opts,
+		Line:       1,
+	}
+}
+
+func TestVerify(t *testing.T) {
+	signer := testutil.NewSigner(t)
+	other := testutil.NewSigner(t)
+
+	signedCommit := withGpgsig(testCommitPayload, testutil.Sign(t, signer, testCommitPayload))
+	signedTag := testTagPayload + testutil.Sign(t, signer, testTagPayload)
+	otherCommit := withGpgsig(testCommitPayload, testutil.Sign(t, other, testCommitPayload))
+	tamperedCommit := strings.Replace(signedCommit, "Add a feature", "Add a backdoor", 1)
+	pgpTag := testTagPayload + "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----\n"
+
+	// The key is only valid until just after the commit was made, and before
+	// the tag was.
+	commitTime := time.Unix(1700000060, 0)
+	opts := verify.Options{
+		AllowedSigners: []verify.AllowedSigner{
+			allowedSigner("committer@example.com", signer, verify.SignerOptions{ValidBefore: commitTime.Add(30 * time.Second)}),
+		},
+	}
+
+	for _, tt := range []struct {
+		name      string
+		objType   string
+		raw       string
+		time      time.Time
+		status    verify.Status
+		principal string
+		err       error
+	}{
+		{
+			name:      "signed commit",
+			objType:   TypeCommit,
+			raw:       signedCommit,
+			status:    verify.StatusGood,
+			principal: "committer@example.com",
+		},
+		{
+			name:      "signed tag",
+			objType:   TypeTag,
+			raw:       signedTag,
+			status:    verify.StatusExpired,
+			principal: "",
+			err:       verify.ErrKeyExpired,
+		},
+		{
+			name:      "signed tag at commit time",
+			objType:   TypeTag,
+			raw:       signedTag,
+			time:      commitTime,
+			status:    verify.StatusGood,
+			principal: "committer@example.com",
+		},
+		{
+			name:    "unknown signer",
+			objType: TypeCommit,
+			raw:     otherCommit,
+			status:  verify.StatusUnknownSigner,
+		},
+		{
+			name:    "tampered commit",
+			objType: TypeCommit,
+			raw:     tamperedCommit,
+			status:  verify.StatusBadSignature,
+		},
+		{
+			name:    "unsigned commit",
+			objType: TypeCommit,
+			raw:     testCommitPayload,
+			status:  verify.StatusUnsigned,
+			err:     ErrUnsigned,
+		},
+		{
+			name:    "openpgp tag",
+			objType: TypeTag,
+			raw:     pgpTag,
+			status:  verify.StatusNonSSH,
+			err:     ErrNonSSHSignature,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var obj *Object
+			var err error
+			if tt.objType == TypeCommit {
+				obj, err = ParseCommit("abc", []byte(tt.raw))
+			} else {
+				obj, err = ParseTag("abc", []byte(tt.raw))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			o := opts
+			o.Time = tt.time
+			v := Verify(obj, o)
+			if v.Object != "abc" || v.Type != tt.objType {
+				t.Errorf("Verify returned %s %s", v.Type, v.Object)
+			}
+			if v.Status != tt.status {
+				t.Fatalf("Verify returned status %s, expected %s: %s", v.Status, tt.status, v.Reason)
+			}
+			if v.Principal != tt.principal {
+				t.Errorf("Verify returned principal %q, expected %q", v.Principal, tt.principal)
+			}
+			if v.Namespace != "git" {
+				t.Errorf("Verify returned namespace %s, expected git", v.Namespace)
+			}
+			if tt.err != nil && !errors.Is(v.Err, tt.err) {
+				t.Errorf("Verify returned error %v, expected %v", v.Err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyMergeTags(t *testing.T) {
+	signer := testutil.NewSigner(t)
+	other := testutil.NewSigner(t)
+
+	goodTag := testTagPayload + testutil.Sign(t, signer, testTagPayload)
+	unknownTag := testTagPayload + testutil.Sign(t, other, testTagPayload)
+	payload := withMergetags(testCommitPayload, goodTag, testTagPayload)
+	commit := withGpgsig(payload, testutil.Sign(t, signer, payload))
+
+	opts := verify.Options{
+		AllowedSigners: []verify.AllowedSigner{allowedSigner("committer@example.com", signer, verify.SignerOptions{})},
+	}
+
+	obj, err := ParseCommit("abc", []byte(commit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := Verify(obj, opts)
+	if v.Status != verify.StatusGood || len(v.MergeTags) != 2 {
+		t.Fatalf("Verify returned %s with %d mergetags", v.Status, len(v.MergeTags))
+	}
+	for i, status := range []verify.Status{verify.StatusGood, verify.StatusUnsigned} {
+		mt := v.MergeTags[i]
+		if mt.Type != TypeMergeTag || mt.Commit != "abc" || mt.Object != obj.MergeTags[i].ID || mt.Status != status {
+			t.Errorf("Verify returned mergetag %s %s in %s: %s, expected %s", mt.Type, mt.Object, mt.Commit, mt.Status, status)
+		}
+	}
+	if v.MergeTagFailed() {
+		t.Error("MergeTagFailed returned true for a good and an unsigned tag")
+	}
+
+	// The commit signature is good, but the tag is signed by an unknown key.
+	payload = withMergetags(testCommitPayload, unknownTag)
+	obj, err = ParseCommit("abc", []byte(withGpgsig(payload, testutil.Sign(t, signer, payload))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v = Verify(obj, opts)
+	if v.Status != verify.StatusGood || v.MergeTags[0].Status != verify.StatusUnknownSigner || !v.MergeTagFailed() {
+		t.Errorf("Verify returned %s with a mergetag %s", v.Status, v.MergeTags[0].Status)
+	}
+}
@@ -0,0 +1,103 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/policy"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+/*
+	The hook is configured with a JSON file on the server, e.g.:
+
+	{
+		"allowed_signers": "/etc/git/allowed_signers",
+		"revocation_file": "/etc/git/revoked_keys",
+		"policy": "/etc/git/signing_policy",
+		"rules": [
+			{"ref": "refs/tags/v*", "principals": ["*@release.example.com"]},
+			{"ref": "refs/heads/sandbox/*", "allow_unsigned": true}
+		]
+	}
+
+	The first rule whose ref pattern matches a pushed reference applies to
+	it. References that match no rule must be signed by any allowed signer.
+	If a signing policy is given, signers must also be authorized by it for
+	the reference and the paths each commit changes.
+*/
+
+type Config struct {
+	// The allowed signers file. Defaults to gpg.ssh.allowedSignersFile of
+	// the repository.
+	AllowedSignersFile string `json:"allowed_signers"`
+	// The revocation file. Defaults to gpg.ssh.revocationFile of the
+	// repository.
+	RevocationFile string `json:"revocation_file"`
+	// The signing policy file, if any.
+	PolicyFile string `json:"policy"`
+	Rules      []Rule `json:"rules"`
+	// The signing policy read from the policy file.
+	Policy *policy.Policy `json:"-"`
+}
+
+type Rule struct {
+	// An OpenSSH style pattern-list of references the rule applies to,
+	// where '*' also matches '/', e.g. "refs/heads/*".
+	Ref string `json:"ref"`
+	// If set, the pattern-list of principals that may sign objects pushed
+	// to the references. Otherwise, any allowed signer may.
+	Principals []string `json:"principals"`
+	// Accept unsigned objects. Signed objects must still verify.
+	AllowUnsigned bool `json:"allow_unsigned"`
+}
+
+// The rule that applies to references that match no other rule.
+var DefaultRule = Rule{Ref: "*"}
+
+// Read the hook configuration file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, r := range config.Rules {
+		if r.Ref == "" {
+			return nil, fmt.Errorf("%s: rule %d: missing ref", path, i+1)
+		}
+	}
+	if config.PolicyFile != "" {
+		if config.Policy, err = policy.Load(config.PolicyFile); err != nil {
+			return nil, err
+		}
+	}
+	return &config, nil
+}
+
+// Returns the first rule that applies to the reference.
+func (c *Config) RuleFor(ref string) Rule {
+	for _, r := range c.Rules {
+		if verify.MatchPatternList(ref, splitPatterns(r.Ref)) {
+			return r
+		}
+	}
+	return DefaultRule
+}
+
+// Split a comma-separated pattern-list.
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
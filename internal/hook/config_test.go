@@ -0,0 +1,93 @@
+package hook
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	policyFile := write("policy", "deploy/ sre@example.com\n")
+	path := write("config.json", `{
+		"allowed_signers": "/etc/git/allowed_signers",
+		"policy": "`+policyFile+`",
+		"rules": [
+			{"ref": "refs/tags/v*", "principals": ["*@release.example.com"]},
+			{"ref": "refs/heads/sandbox/*", "allow_unsigned": true}
+		]
+	}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Policy == nil || len(config.Policy.Paths) != 1 {
+		t.Errorf("LoadConfig returned policy %+v", config.Policy)
+	}
+	config.Policy = nil
+
+	expected := &Config{
+		AllowedSignersFile: "/etc/git/allowed_signers",
+		PolicyFile:         policyFile,
+		Rules: []Rule{
+			{Ref: "refs/tags/v*", Principals: []string{"*@release.example.com"}},
+			{Ref: "refs/heads/sandbox/*", AllowUnsigned: true},
+		},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("LoadConfig returned %+v, expected %+v", config, expected)
+	}
+
+	for name, content := range map[string]string{
+		"invalid.json":     `{"rules": [`,
+		"missing-ref.json": `{"rules": [{"principals": ["*"]}]}`,
+		"bad-policy.json":  `{"policy": "` + write("bad-policy", "deploy/ @sre\n") + `"}`,
+	} {
+		if _, err := LoadConfig(write(name, content)); err == nil {
+			t.Errorf("LoadConfig returned no error for %s", name)
+		}
+	}
+	if _, err := LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadConfig returned no error for a missing file")
+	}
+}
+
+func TestRuleFor(t *testing.T) {
+	config := &Config{Rules: []Rule{
+		{Ref: "refs/tags/v*", Principals: []string{"release"}},
+		{Ref: "refs/heads/sandbox/*, refs/heads/tmp/*", AllowUnsigned: true},
+		{Ref: "refs/heads/*, !refs/heads/main", Principals: []string{"dev"}},
+	}}
+
+	tests := []struct {
+		ref      string
+		expected int
+	}{
+		{"refs/tags/v1.0.0", 0},
+		{"refs/tags/latest", -1},
+		{"refs/heads/sandbox/alice/test", 1},
+		{"refs/heads/tmp/x", 1},
+		{"refs/heads/feature", 2},
+		{"refs/heads/main", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			expected := DefaultRule
+			if tt.expected >= 0 {
+				expected = config.Rules[tt.expected]
+			}
+			if rule := config.RuleFor(tt.ref); !reflect.DeepEqual(rule, expected) {
+				t.Errorf("RuleFor returned %+v, expected %+v", rule, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package hook
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/policy"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+/*
+	A server-side hook rejects pushes that introduce commits or tags that are
+	not signed as the rules of the references they are pushed to require.
+
+	git runs the pre-receive hook once per push, with a line of
+	"<old> <new> <ref>" on standard input for each reference being updated,
+	and the update hook once per reference, with the same values as
+	arguments. The objects are in the repository, but the references have not
+	been updated yet, so the commits a push introduces are those reachable
+	from the new value of a reference, but not from its old value or, for a
+	new reference, from any existing reference. The new value itself is
+	always checked against the rule of the reference, even if it was already
+	in the repository.
+*/
+
+// An update of a reference, as passed to the pre-receive and update hooks.
+// Old is all zeros if the reference is created, and New if it is deleted.
+type Update struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+	Ref string `json:"ref"`
+}
+
+// Read the "<old> <new> <ref>" lines passed to the pre-receive hook.
+func ParseUpdates(r io.Reader) ([]Update, error) {
+	var updates []Update
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected '<old> <new> <ref>'", n)
+		}
+		u := Update{Old: fields[0], New: fields[1], Ref: fields[2]}
+		if err := u.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		updates = append(updates, u)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+func (u Update) validate() error {
+	if !gitobj.IsObjectName(u.Old) {
+		return fmt.Errorf("invalid object name '%s'", u.Old)
+	}
+	if !gitobj.IsObjectName(u.New) {
+		return fmt.Errorf("invalid object name '%s'", u.New)
+	}
+	if !strings.HasPrefix(u.Ref, "refs/") {
+		return fmt.Errorf("invalid reference '%s'", u.Ref)
+	}
+	return nil
+}
+
+// The verification of an object a push introduces, the reference it is
+// pushed to, and whether the rule of the reference rejects it.
+type Result struct {
+	Ref string
+	*gitobj.Verification
+	Rejected bool
+}
+
+// An object that was rejected, and the reference it was pushed to.
+type Rejection struct {
+	Ref string
+	*gitobj.Verification
+}
+
+func (r Rejection) String() string {
+	return fmt.Sprintf("%s: %s %s: %s: %s", r.Ref, r.Type, r.Object, r.Status, r.Reason)
+}
+
+// Check the objects introduced by the updates against the rules and signing
+// policy of the configuration, and return those that are rejected. The
+// allowed signers and revoked keys are taken from the options, and
+// signatures are verified at the time of the push unless a time is given.
+func Check(repo gitobj.Repo, config *Config, updates []Update, opts verify.Options) ([]Rejection, error) {
+	results, err := Verify(repo, config, updates, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var rejections []Rejection
+	for _, r := range results {
+		if r.Rejected {
+			rejections = append(rejections, Rejection{Ref: r.Ref, Verification: r.Verification})
+		}
+	}
+	return rejections, nil
+}
+
+// Verify the objects introduced by the updates, as Check does, and return
+// the result for each of them, whether it is rejected or not, in the order
+// of the updates.
+func Verify(repo gitobj.Repo, config *Config, updates []Update, opts verify.Options) ([]Result, error) {
+	if opts.Time.IsZero() {
+		opts.Time = time.Now()
+	}
+
+	batch, err := repo.NewBatch()
+	if err != nil {
+		return nil, err
+	}
+	defer batch.Close()
+
+	// The paths commits change are listed by a single git process, and only
+	// if the policy has rules for paths.
+	var lister policy.PathLister
+	if config.Policy != nil && config.Policy.HasPathRules() {
+		diffTree, err := repo.NewDiffTree()
+		if err != nil {
+			return nil, err
+		}
+		defer diffTree.Close()
+		lister = diffTree
+	}
+
+	var results []Result
+	for _, u := range updates {
+		if err := u.validate(); err != nil {
+			return nil, err
+		}
+		// Deleting a reference introduces no objects.
+		if gitobj.IsZero(u.New) {
+			continue
+		}
+
+		ids, err := introducedObjects(repo, u)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", u.Ref, err)
+		}
+
+		rule := config.RuleFor(u.Ref)
+		ruleOpts := opts
+		ruleOpts.PermittedPrincipals = rule.Principals
+		for _, id := range ids {
+			obj, err := batch.ReadObject(id)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", u.Ref, err)
+			}
+			v := gitobj.Verify(obj, ruleOpts)
+			if config.Policy != nil {
+				config.Policy.ApplyObject(lister, obj, v, u.Ref)
+			}
+			results = append(results, Result{Ref: u.Ref, Verification: v, Rejected: !rule.accepts(v)})
+		}
+	}
+	return results, nil
+}
+
+// List the objects the update introduces: the tag, if an annotated tag is
+// pushed, the commit at the tip, and the commits that are not reachable from
+// the old value of the reference or, for a new reference, from any existing
+// reference. The tip is always included, even if it is already in the
+// repository, e.g. a tag or a protected branch created on an existing commit.
+func introducedObjects(repo gitobj.Repo, u Update) ([]string, error) {
+	var ids []string
+	objType, err := repo.ObjectType(u.New)
+	if err != nil {
+		return nil, err
+	}
+	tip := u.New
+	if objType == gitobj.TypeTag {
+		ids = append(ids, u.New)
+		// Tags of objects other than commits have no commits to verify.
+		if tip, err = repo.Resolve(u.New, gitobj.TypeCommit); err != nil {
+			return ids, nil
+		}
+	} else if objType != gitobj.TypeCommit {
+		return nil, nil
+	}
+
+	var commits []string
+	if gitobj.IsZero(u.Old) {
+		commits, err = repo.NewCommits(tip)
+	} else {
+		commits, err = repo.RevList(tip, "^"+u.Old)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids = append(ids, tip)
+	for _, c := range commits {
+		if c != tip {
+			ids = append(ids, c)
+		}
+	}
+	return ids, nil
+}
+
+// Returns true if the verification satisfies the rule: the signature is
+// good and, if the rule restricts them, made by one of its principals.
+// Objects without an SSH signature are accepted if the rule allows
+// unsigned objects.
+func (r Rule) accepts(v *gitobj.Verification) bool {
+	switch v.Status {
+	case verify.StatusGood:
+		return true
+	case verify.StatusUnsigned, verify.StatusNonSSH:
+		return r.AllowUnsigned
+	default:
+		return false
+	}
+}
@@ -0,0 +1,283 @@
+package hook
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/policy"
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+	"golang.org/x/crypto/ssh"
+)
+
+const zero = "0000000000000000000000000000000000000000"
+
+// An allowed signer with the key of the signer.
+func allowedSigner(signer ssh.Signer, principal string) verify.AllowedSigner {
+	return verify.AllowedSigner{
+		Email:      principal,
+		Principals: []string{principal},
+		PublicKey:  testutil.AuthorizedKey(signer.PublicKey()),
+		Line:       1,
+	}
+}
+
+func TestParseUpdates(t *testing.T) {
+	old := strings.Repeat("a", 40)
+	updates, err := ParseUpdates(strings.NewReader(old + " " + zero + " refs/heads/main\n\n" + zero + " " + old + " refs/tags/v1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Update{{old, zero, "refs/heads/main"}, {zero, old, "refs/tags/v1"}}
+	if len(updates) != len(expected) || updates[0] != expected[0] || updates[1] != expected[1] {
+		t.Errorf("ParseUpdates returned %v, expected %v", updates, expected)
+	}
+
+	for _, input := range []string{
+		old + " " + old + "\n",
+		old + " main refs/heads/main\n",
+		old + " " + old + " main\n",
+	} {
+		if _, err := ParseUpdates(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseUpdates returned no error for %q", input)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	alice := testutil.NewSigner(t)
+	release := testutil.NewSigner(t)
+	mallory := testutil.NewSigner(t)
+	repo := testutil.NewBareRepo(t)
+
+	base := repo.Commit(alice, repo.Tree, "", "base")
+	repo.UpdateRef("refs/heads/main", base)
+
+	signed := repo.Commit(alice, repo.Tree, base, "signed")
+	unsigned := repo.Commit(nil, repo.Tree, signed, "unsigned")
+	unknown := repo.Commit(mallory, repo.Tree, base, "unknown")
+	byRelease := repo.Commit(release, repo.Tree, base, "release")
+	aliceTag := repo.Tag(alice, "v1", base)
+	releaseTag := repo.Tag(release, "v1", byRelease)
+
+	config := &Config{Rules: []Rule{
+		{Ref: "refs/tags/v*", Principals: []string{"*@release.example.com"}},
+		{Ref: "refs/heads/sandbox/*", AllowUnsigned: true},
+	}}
+	opts := verify.Options{AllowedSigners: []verify.AllowedSigner{
+		allowedSigner(alice, "alice@example.com"),
+		allowedSigner(release, "ci@release.example.com"),
+	}}
+
+	tests := []struct {
+		name     string
+		update   Update
+		rejected []string
+		status   verify.Status
+	}{
+		{"signed", Update{base, signed, "refs/heads/main"}, nil, ""},
+		{"unsigned", Update{base, unsigned, "refs/heads/main"}, []string{unsigned}, verify.StatusUnsigned},
+		{"unknown signer", Update{zero, unknown, "refs/heads/feature"}, []string{unknown}, verify.StatusUnknownSigner},
+		{"unsigned allowed", Update{zero, unsigned, "refs/heads/sandbox/test"}, nil, ""},
+		{"unknown signer on sandbox", Update{zero, unknown, "refs/heads/sandbox/test"}, []string{unknown}, verify.StatusUnknownSigner},
+		{"tag by release", Update{zero, releaseTag, "refs/tags/v1"}, nil, ""},
+		// The tagged commit is checked too, although it is not new.
+		{"tag not by release", Update{zero, aliceTag, "refs/tags/v1"}, []string{aliceTag, base}, verify.StatusNotAllowed},
+		{"lightweight tag", Update{zero, base, "refs/tags/v1"}, []string{base}, verify.StatusNotAllowed},
+		{"release on main", Update{base, byRelease, "refs/heads/main"}, nil, ""},
+		{"deletion", Update{base, zero, "refs/heads/main"}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejections, err := Check(gitobj.Repo{Dir: repo.Dir}, config, []Update{tt.update}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rejections) != len(tt.rejected) {
+				t.Fatalf("Check returned %v, expected %d rejections", rejections, len(tt.rejected))
+			}
+			for i, r := range rejections {
+				if r.Object != tt.rejected[i] || r.Ref != tt.update.Ref {
+					t.Errorf("Check rejected %s on %s, expected %s", r.Object, r.Ref, tt.rejected[i])
+				}
+			}
+			if len(rejections) > 0 && rejections[0].Status != tt.status {
+				t.Errorf("Check rejected %s with %s, expected %s", rejections[0].Object, rejections[0].Status, tt.status)
+			}
+		})
+	}
+
+	// Commits already reachable from a reference are not checked again,
+	// unless they are the new tip.
+	repo.UpdateRef("refs/heads/legacy", unsigned)
+	onLegacy := repo.Commit(alice, repo.Tree, unsigned, "on legacy")
+	rejections, err := Check(gitobj.Repo{Dir: repo.Dir}, config, []Update{{zero, onLegacy, "refs/heads/copy"}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejections) != 0 {
+		t.Errorf("Check rejected %v, which were already in the repository", rejections)
+	}
+	rejections, err = Check(gitobj.Repo{Dir: repo.Dir}, config, []Update{{zero, unsigned, "refs/heads/copy"}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejections) != 1 || rejections[0].Object != unsigned {
+		t.Errorf("Check returned %v for a branch created on an unsigned commit", rejections)
+	}
+
+	if _, err := Check(gitobj.Repo{Dir: repo.Dir}, config, []Update{{zero, strings.Repeat("1", 40), "refs/heads/main"}}, opts); err == nil {
+		t.Error("Check returned no error for a missing object")
+	}
+}
+
+func TestCheckCreatedRef(t *testing.T) {
+	alice := testutil.NewSigner(t)
+	repo := testutil.NewBareRepo(t)
+
+	// An unprotected branch with an unsigned commit, already accepted.
+	base := repo.Commit(alice, repo.Tree, "", "base")
+	unsigned := repo.Commit(nil, repo.Tree, base, "unsigned")
+	repo.UpdateRef("refs/heads/main", base)
+	repo.UpdateRef("refs/heads/sandbox/test", unsigned)
+
+	config := &Config{Rules: []Rule{
+		{Ref: "refs/tags/v*", Principals: []string{"alice@example.com"}},
+		{Ref: "refs/heads/sandbox/*", AllowUnsigned: true},
+	}}
+	opts := verify.Options{AllowedSigners: []verify.AllowedSigner{allowedSigner(alice, "alice@example.com")}}
+
+	// References created on existing commits are checked against their rule.
+	for _, ref := range []string{"refs/tags/v1", "refs/heads/release"} {
+		rejections, err := Check(gitobj.Repo{Dir: repo.Dir}, config, []Update{{zero, unsigned, ref}}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rejections) != 1 || rejections[0].Object != unsigned || rejections[0].Status != verify.StatusUnsigned {
+			t.Errorf("Check returned %v for %s on an existing unsigned commit", rejections, ref)
+		}
+	}
+
+	rejections, err := Check(gitobj.Repo{Dir: repo.Dir}, config, []Update{{zero, base, "refs/tags/v1"}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejections) != 0 {
+		t.Errorf("Check rejected %v for a tag of an existing signed commit", rejections)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	alice := testutil.NewSigner(t)
+	repo := testutil.NewBareRepo(t)
+
+	base := repo.Commit(alice, repo.Tree, "", "base")
+	repo.UpdateRef("refs/heads/main", base)
+	signed := repo.Commit(alice, repo.Tree, base, "signed")
+	unsigned := repo.Commit(nil, repo.Tree, signed, "unsigned")
+	sandbox := repo.Commit(nil, repo.Tree, base, "sandbox")
+
+	config := &Config{Rules: []Rule{{Ref: "refs/heads/sandbox/*", AllowUnsigned: true}}}
+	opts := verify.Options{AllowedSigners: []verify.AllowedSigner{allowedSigner(alice, "alice@example.com")}}
+
+	// Every object is returned, not only those that are rejected.
+	results, err := Verify(gitobj.Repo{Dir: repo.Dir}, config, []Update{
+		{base, unsigned, "refs/heads/main"},
+		{zero, sandbox, "refs/heads/sandbox/test"},
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		ref      string
+		object   string
+		status   verify.Status
+		rejected bool
+	}{
+		{"refs/heads/main", unsigned, verify.StatusUnsigned, true},
+		{"refs/heads/main", signed, verify.StatusGood, false},
+		{"refs/heads/sandbox/test", sandbox, verify.StatusUnsigned, false},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Verify returned %d results, expected %d", len(results), len(expected))
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.Ref != e.ref || r.Object != e.object || r.Status != e.status || r.Rejected != e.rejected {
+			t.Errorf("result %d is %s %s %s rejected=%v, expected %s %s %s rejected=%v",
+				i, r.Ref, r.Object, r.Status, r.Rejected, e.ref, e.object, e.status, e.rejected)
+		}
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	alice := testutil.NewSigner(t)
+	bob := testutil.NewSigner(t)
+	mallory := testutil.NewSigner(t)
+	repo := testutil.NewBareRepo(t)
+
+	base := repo.Commit(alice, repo.Tree, "", "base")
+	repo.UpdateRef("refs/heads/main", base)
+
+	deploy := repo.TreeWith("deploy", "app.yaml")
+	docs := repo.TreeWith("docs", "index.md")
+	byAlice := repo.Commit(alice, deploy, base, "deploy by alice")
+	byBob := repo.Commit(bob, deploy, base, "deploy by bob")
+	docsByAlice := repo.Commit(alice, docs, base, "docs by alice")
+	byMallory := repo.Commit(mallory, deploy, base, "deploy by mallory")
+
+	p, err := policy.Parse(strings.NewReader("group sre bob@example.com\n* *@example.com\ndeploy/ @sre\n"), "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Policy: p}
+	opts := verify.Options{AllowedSigners: []verify.AllowedSigner{
+		allowedSigner(alice, "alice@example.com"),
+		allowedSigner(bob, "bob@example.com"),
+	}}
+
+	tests := []struct {
+		name   string
+		commit string
+		status verify.Status
+	}{
+		{"owner", byBob, ""},
+		{"other path", docsByAlice, ""},
+		{"unauthorized", byAlice, verify.StatusUnauthorized},
+		{"unknown signer", byMallory, verify.StatusUnknownSigner},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejections, err := Check(gitobj.Repo{Dir: repo.Dir}, config, []Update{{base, tt.commit, "refs/heads/main"}}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status == "" {
+				if len(rejections) != 0 {
+					t.Errorf("Check returned %v, expected no rejections", rejections)
+				}
+				return
+			}
+			if len(rejections) != 1 || rejections[0].Object != tt.commit || rejections[0].Status != tt.status {
+				t.Errorf("Check returned %v, expected %s to be rejected as %s", rejections, tt.commit, tt.status)
+			}
+		})
+	}
+}
+
+func TestRejectionString(t *testing.T) {
+	r := Rejection{
+		Ref: "refs/heads/main",
+		Verification: &gitobj.Verification{
+			Object: "abc",
+			Type:   "commit",
+			Result: &verify.Result{Status: verify.StatusUnsigned, Reason: "no signature found"},
+		},
+	}
+	expected := "refs/heads/main: commit abc: unsigned: no signature found"
+	if r.String() != expected {
+		t.Errorf("String returned %q, expected %q", r.String(), expected)
+	}
+}
@@ -0,0 +1,162 @@
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+/*
+	With receive.certNonceSeed set, a client pushing with `git push --signed`
+	signs a push certificate listing the updates of the push, and a nonce
+	given to it by the server. git stores the certificate as a blob and
+	passes it to the hooks in environment variables:
+
+		GIT_PUSH_CERT               the name of the blob
+		GIT_PUSH_CERT_NONCE         the nonce the server gave the client
+		GIT_PUSH_CERT_NONCE_STATUS  whether the certificate has the nonce
+
+	The certificate is verified in the "git" namespace, like commits, and
+	binds the pusher to an allowed signer, so that who pushed can be
+	recorded, rather than only who committed.
+*/
+
+var (
+	ErrNoPushCert     = errors.New("no push certificate; push with --signed")
+	ErrBadNonce       = errors.New("push certificate nonce is not valid")
+	ErrPusherMismatch = errors.New("pusher does not match the signer")
+	ErrNotCertified   = errors.New("update is not in the push certificate")
+)
+
+// Nonce statuses of GIT_PUSH_CERT_NONCE_STATUS that are accepted: the nonce
+// is the one the server gave, or one it gave within receive.certNonceSlop
+// seconds.
+const (
+	NonceOK   = "OK"
+	NonceSlop = "SLOP"
+)
+
+// The push certificate of a push, as passed to hooks by git.
+type PushCertEnv struct {
+	Cert        string
+	Nonce       string
+	NonceStatus string
+}
+
+// Read the push certificate environment variables with the given function,
+// e.g. os.Getenv.
+func PushCertEnvFrom(getenv func(string) string) PushCertEnv {
+	return PushCertEnv{
+		Cert:        getenv("GIT_PUSH_CERT"),
+		Nonce:       getenv("GIT_PUSH_CERT_NONCE"),
+		NonceStatus: getenv("GIT_PUSH_CERT_NONCE_STATUS"),
+	}
+}
+
+// The result of verifying a push certificate, with who pushed what where.
+type PushCertVerification struct {
+	*gitobj.Verification
+	Pusher      string    `json:"pusher,omitempty"`
+	Pushee      string    `json:"pushee,omitempty"`
+	Nonce       string    `json:"nonce,omitempty"`
+	NonceStatus string    `json:"nonce_status,omitempty"`
+	Time        time.Time `json:"time"`
+	Updates     []Update  `json:"updates,omitempty"`
+}
+
+// Verify the push certificate of a push against the allowed signers and
+// revoked keys of the options, at the time of the push unless a time is
+// given. The nonce must be valid, the pusher must be the signer, and each of
+// the updates being received must be certified.
+func VerifyPushCert(repo gitobj.Repo, env PushCertEnv, updates []Update, opts verify.Options) *PushCertVerification {
+	if opts.Time.IsZero() {
+		opts.Time = time.Now()
+	}
+
+	pv := &PushCertVerification{
+		Verification: &gitobj.Verification{Object: env.Cert, Type: gitobj.TypePushCert, Result: &verify.Result{}},
+		NonceStatus:  env.NonceStatus,
+		Time:         opts.Time,
+	}
+	if env.Cert == "" {
+		pv.Fail(verify.StatusUnsigned, ErrNoPushCert)
+		return pv
+	}
+
+	cert, err := repo.ReadPushCert(env.Cert)
+	if err != nil {
+		pv.Fail(verify.StatusError, err)
+		return pv
+	}
+	pv.Pusher = cert.Pusher()
+	pv.Pushee, _ = cert.Header("pushee")
+	pv.Nonce, _ = cert.Header("nonce")
+	pv.Time = cert.Time
+	pv.Updates, err = ParseUpdates(strings.NewReader(strings.Join(cert.Message(), "\n")))
+	if err != nil {
+		pv.Fail(verify.StatusError, fmt.Errorf("invalid push certificate: %w", err))
+		return pv
+	}
+
+	pv.Verification = gitobj.Verify(cert, opts)
+	if pv.Status != verify.StatusGood {
+		return pv
+	}
+	if err := pv.check(env, updates); err != nil {
+		pv.Fail(verify.StatusNotAllowed, err)
+	}
+	return pv
+}
+
+// Check the nonce, the pusher and the updates of a good push certificate.
+func (pv *PushCertVerification) check(env PushCertEnv, updates []Update) error {
+	if env.NonceStatus != NonceOK && env.NonceStatus != NonceSlop {
+		return fmt.Errorf("%w: %s", ErrBadNonce, strings.ToLower(env.NonceStatus))
+	}
+	if pv.Nonce != env.Nonce {
+		return fmt.Errorf("%w: expected %s", ErrBadNonce, env.Nonce)
+	}
+
+	if !pv.pusherIsSigner() {
+		return fmt.Errorf("%w: %s", ErrPusherMismatch, pv.Pusher)
+	}
+
+	for _, u := range updates {
+		if !pv.certifies(u) {
+			return fmt.Errorf("%w: %s %s %s", ErrNotCertified, u.Old, u.New, u.Ref)
+		}
+	}
+	return nil
+}
+
+// Returns true if the pusher is the signer of the certificate: the pusher is
+// the fingerprint of the key for SSH signatures. An ident, as git writes
+// for other signature formats, is accepted if its email is a principal of
+// the allowed signer.
+func (pv *PushCertVerification) pusherIsSigner() bool {
+	if strings.HasPrefix(pv.Pusher, "SHA256:") {
+		return pv.Pusher == pv.Fingerprint
+	}
+
+	email := gitobj.IdentEmail(pv.Pusher)
+	if email == "" {
+		return false
+	}
+	if email == pv.Principal {
+		return true
+	}
+	return pv.Signer != nil && !pv.Signer.Options.CertAuthority && verify.MatchPatternList(email, pv.Signer.Principals)
+}
+
+func (pv *PushCertVerification) certifies(u Update) bool {
+	for _, c := range pv.Updates {
+		if c == u {
+			return true
+		}
+	}
+	return false
+}
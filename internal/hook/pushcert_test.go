@@ -0,0 +1,149 @@
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+	"golang.org/x/crypto/ssh"
+)
+
+// Write a push certificate of the updates, signed by the signer if given,
+// and return the name of its blob.
+func pushCert(t *testing.T, repo *testutil.Repo, signer ssh.AlgorithmSigner, pusher, nonce string, updates ...Update) string {
+	t.Helper()
+	payload := fmt.Sprintf("certificate version 0.1\npusher %s %d +0000\npushee git@example.com:repo.git\nnonce %s\n\n", pusher, repo.Time(), nonce)
+	for _, u := range updates {
+		payload += u.Old + " " + u.New + " " + u.Ref + "\n"
+	}
+	return repo.WriteObject("blob", payload+testutil.Sign(t, signer, payload))
+}
+
+func TestVerifyPushCert(t *testing.T) {
+	alice := testutil.NewSigner(t)
+	mallory := testutil.NewSigner(t)
+	repo := testutil.NewBareRepo(t)
+
+	base := repo.Commit(alice, repo.Tree, "", "base")
+	update := Update{zero, base, "refs/heads/main"}
+	other := Update{zero, base, "refs/heads/other"}
+	aliceKey := ssh.FingerprintSHA256(alice.PublicKey())
+	nonce := "1700000000-abc"
+
+	opts := verify.Options{AllowedSigners: []verify.AllowedSigner{allowedSigner(alice, "alice@example.com")}}
+	env := func(cert string) PushCertEnv {
+		return PushCertEnv{Cert: cert, Nonce: nonce, NonceStatus: NonceOK}
+	}
+
+	tests := []struct {
+		name    string
+		env     PushCertEnv
+		updates []Update
+		status  verify.Status
+		err     error
+	}{
+		{
+			name:    "good",
+			env:     env(pushCert(t, repo, alice, aliceKey, nonce, update, other)),
+			updates: []Update{update},
+			status:  verify.StatusGood,
+		},
+		{
+			name:    "pusher ident",
+			env:     env(pushCert(t, repo, alice, "A Lice <alice@example.com>", nonce, update)),
+			updates: []Update{update},
+			status:  verify.StatusGood,
+		},
+		{
+			name:   "unsigned push",
+			env:    PushCertEnv{},
+			status: verify.StatusUnsigned,
+			err:    ErrNoPushCert,
+		},
+		{
+			name:   "unsigned certificate",
+			env:    env(pushCert(t, repo, nil, aliceKey, nonce, update)),
+			status: verify.StatusUnsigned,
+		},
+		{
+			name:   "unknown signer",
+			env:    env(pushCert(t, repo, mallory, ssh.FingerprintSHA256(mallory.PublicKey()), nonce, update)),
+			status: verify.StatusUnknownSigner,
+		},
+		{
+			name:   "bad nonce",
+			env:    PushCertEnv{Cert: pushCert(t, repo, alice, aliceKey, nonce, update), Nonce: nonce, NonceStatus: "BAD"},
+			status: verify.StatusNotAllowed,
+			err:    ErrBadNonce,
+		},
+		{
+			name:   "slop nonce",
+			env:    PushCertEnv{Cert: pushCert(t, repo, alice, aliceKey, "1699999999-abc", update), Nonce: "1699999999-abc", NonceStatus: NonceSlop},
+			status: verify.StatusGood,
+		},
+		{
+			name:   "other nonce",
+			env:    env(pushCert(t, repo, alice, aliceKey, "1699999999-abc", update)),
+			status: verify.StatusNotAllowed,
+			err:    ErrBadNonce,
+		},
+		{
+			name:   "other pusher",
+			env:    env(pushCert(t, repo, alice, ssh.FingerprintSHA256(mallory.PublicKey()), nonce, update)),
+			status: verify.StatusNotAllowed,
+			err:    ErrPusherMismatch,
+		},
+		{
+			name:   "other pusher ident",
+			env:    env(pushCert(t, repo, alice, "M Allory <mallory@example.com>", nonce, update)),
+			status: verify.StatusNotAllowed,
+			err:    ErrPusherMismatch,
+		},
+		{
+			name:    "update not certified",
+			env:     env(pushCert(t, repo, alice, aliceKey, nonce, update)),
+			updates: []Update{update, other},
+			status:  verify.StatusNotAllowed,
+			err:     ErrNotCertified,
+		},
+		{
+			name:   "missing certificate",
+			env:    env(strings.Repeat("1", 40)),
+			status: verify.StatusError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pv := VerifyPushCert(gitobj.Repo{Dir: repo.Dir}, tt.env, tt.updates, opts)
+			if pv.Status != tt.status {
+				t.Fatalf("VerifyPushCert returned %s, expected %s: %s", pv.Status, tt.status, pv.Reason)
+			}
+			if tt.err != nil && !errors.Is(pv.Err, tt.err) {
+				t.Errorf("VerifyPushCert returned error %v, expected %v", pv.Err, tt.err)
+			}
+			if pv.Type != gitobj.TypePushCert || pv.Object != tt.env.Cert {
+				t.Errorf("VerifyPushCert returned %s %s", pv.Type, pv.Object)
+			}
+			if tt.status == verify.StatusGood && (pv.Principal != "alice@example.com" || pv.Pushee != "git@example.com:repo.git" || len(pv.Updates) == 0) {
+				t.Errorf("VerifyPushCert returned %+v by %s", pv, pv.Principal)
+			}
+		})
+	}
+}
+
+func TestPushCertEnvFrom(t *testing.T) {
+	vars := map[string]string{
+		"GIT_PUSH_CERT":              "abc",
+		"GIT_PUSH_CERT_NONCE":        "1700000000-abc",
+		"GIT_PUSH_CERT_NONCE_STATUS": "OK",
+	}
+	env := PushCertEnvFrom(func(name string) string { return vars[name] })
+	expected := PushCertEnv{Cert: "abc", Nonce: "1700000000-abc", NonceStatus: NonceOK}
+	if env != expected {
+		t.Errorf("PushCertEnvFrom returned %+v, expected %+v", env, expected)
+	}
+}
@@ -0,0 +1,125 @@
+// Package keys resolves the signing key git is configured with, in
+// user.signingkey, to a signer from the source the key is held in.
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
+	"github.com/Keeper-Security/git-ssh-sign/internal/vault"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	A signing key is referred to by a URI naming its provider, followed by
+	a reference the provider resolves to a key:
+
+		keeper://<UID>           the SSH key of a record in the Vault
+		agent://<fingerprint>    a key held by ssh-agent
+		file:///path/to/key      a private key file
+
+	For compatibility, a key without a scheme is a key in ssh-agent if it
+	is a public key, a file holding one, or a SHA256 fingerprint, as for
+	ssh-keygen, and the UID of a record in the Vault otherwise.
+*/
+
+// The schemes of the default providers.
+const (
+	SchemeKeeper = "keeper"
+	SchemeAgent  = "agent"
+	SchemeFile   = "file"
+)
+
+var ErrUnknownProvider = errors.New("unknown key provider")
+
+// A source of signing keys, e.g. the Vault or ssh-agent.
+type KeyProvider interface {
+	// Returns a signer for the key the reference refers to, e.g. the UID of
+	// a Vault record.
+	Signer(ref string) (ssh.AlgorithmSigner, error)
+}
+
+// The key providers, by the scheme of the URIs of their keys.
+type Registry struct {
+	providers map[string]KeyProvider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{providers: map[string]KeyProvider{}}
+}
+
+// Returns a registry with the Vault, ssh-agent and file providers.
+func Default() *Registry {
+	r := NewRegistry()
+	r.Register(SchemeKeeper, vault.Provider{})
+	r.Register(SchemeAgent, &sign.AgentProvider{})
+	r.Register(SchemeFile, sign.FileProvider{})
+	return r
+}
+
+// Register the provider for keys with the given scheme, replacing any
+// provider registered for it before.
+func (r *Registry) Register(scheme string, p KeyProvider) {
+	r.providers[scheme] = p
+}
+
+// Returns a signer for the key, given as a URI, e.g. "keeper://<UID>", or
+// without a scheme as described above.
+func (r *Registry) Signer(key string) (ssh.AlgorithmSigner, error) {
+	scheme, ref := ParseRef(key)
+	p, ok := r.providers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'; try %s", ErrUnknownProvider, scheme, r.schemes())
+	}
+	return p.Signer(ref)
+}
+
+// Close the providers that hold resources, e.g. a connection to ssh-agent.
+func (r *Registry) Close() error {
+	var errs []error
+	for _, p := range r.providers {
+		if c, ok := p.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Registry) schemes() string {
+	var schemes []string
+	for s := range r.providers {
+		schemes = append(schemes, s+"://")
+	}
+	sort.Strings(schemes)
+	return strings.Join(schemes, ", ")
+}
+
+// Split a key into the scheme of its provider and the reference the
+// provider resolves, e.g. "agent" and "SHA256:..." for "agent://SHA256:...".
+func ParseRef(key string) (string, string) {
+	if scheme, ref, ok := strings.Cut(key, "://"); ok {
+		return scheme, ref
+	}
+
+	// git writes a user.signingkey of "key::<public key>" to a temporary
+	// file, and passes the path of the file.
+	ref := key
+	if b, err := os.ReadFile(key); err == nil {
+		ref = string(b)
+	}
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "SHA256:") {
+		return SchemeAgent, ref
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(ref)); err == nil {
+		return SchemeAgent, ref
+	}
+	return SchemeKeeper, key
+}
@@ -0,0 +1,128 @@
+package keys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// A provider of a single key, which records the references it was asked for.
+type fakeProvider struct {
+	signer ssh.AlgorithmSigner
+	refs   []string
+	closed bool
+}
+
+func (p *fakeProvider) Signer(ref string) (ssh.AlgorithmSigner, error) {
+	p.refs = append(p.refs, ref)
+	if ref != "known" {
+		return nil, errors.New("key not found")
+	}
+	return p.signer, nil
+}
+
+func (p *fakeProvider) Close() error {
+	p.closed = true
+	return nil
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	return &fakeProvider{signer: testutil.NewSigner(t)}
+}
+
+func TestParseRef(t *testing.T) {
+	signer := testutil.NewSigner(t)
+	pub := testutil.AuthorizedKey(signer.PublicKey())
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	// git writes a "key::" user.signingkey to a file.
+	pubFile := filepath.Join(t.TempDir(), "key.pub")
+	if err := os.WriteFile(pubFile, []byte(pub+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		key    string
+		scheme string
+		ref    string
+	}{
+		{"keeper://ABC123", SchemeKeeper, "ABC123"},
+		{"agent://" + fingerprint, SchemeAgent, fingerprint},
+		{"file:///home/me/.ssh/id_ed25519", SchemeFile, "/home/me/.ssh/id_ed25519"},
+		{"fake://known", "fake", "known"},
+		{"ABC123", SchemeKeeper, "ABC123"},
+		{fingerprint, SchemeAgent, fingerprint},
+		{pub, SchemeAgent, pub},
+		{pubFile, SchemeAgent, pub},
+	} {
+		scheme, ref := ParseRef(tt.key)
+		if scheme != tt.scheme || ref != tt.ref {
+			t.Errorf("ParseRef(%q) returned %q, %q, expected %q, %q", tt.key, scheme, ref, tt.scheme, tt.ref)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	fake := newFakeProvider(t)
+	r := NewRegistry()
+	r.Register("fake", fake)
+	r.Register(SchemeKeeper, fake)
+
+	for _, tt := range []struct {
+		name string
+		key  string
+		err  string
+	}{
+		{"known key", "fake://known", ""},
+		{"key without a scheme", "known", ""},
+		{"unknown key", "fake://unknown", "key not found"},
+		{"unknown scheme", "vault://known", "unknown key provider 'vault'; try fake://, keeper://"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			as, err := r.Signer(tt.key)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("Signer returned %v, expected %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if as != fake.signer {
+				t.Error("Signer returned a signer other than the provider's")
+			}
+		})
+	}
+
+	if _, err := r.Signer("vault://known"); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("Signer returned %v for an unknown scheme", err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.closed {
+		t.Error("Close did not close the provider")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	r := Default()
+	for _, scheme := range []string{SchemeKeeper, SchemeAgent, SchemeFile} {
+		if _, ok := r.providers[scheme]; !ok {
+			t.Errorf("Default has no provider for %s://", scheme)
+		}
+	}
+
+	// Keys are resolved by their provider, here the file provider.
+	_, err := r.Signer("file://" + filepath.Join(t.TempDir(), "missing"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Signer returned %v for a missing key file", err)
+	}
+}
@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"path"
+	"strings"
+)
+
+// Match a path against a CODEOWNERS style pattern, which follows the rules of
+// gitignore: a pattern with a '/' other than at its end is relative to the
+// root of the repository, while other patterns match at any depth. '*'
+// matches anything but a '/', and '**' matches any number of directories.
+// As in CODEOWNERS, a pattern that names a directory, e.g. "deploy/" or
+// "/apps/web", matches every file below it, while "docs/*" only matches the
+// files directly in docs.
+func matchPath(pattern, p string) bool {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.Trim(pattern, "/")
+	if !anchored {
+		pattern = "**/" + pattern
+	}
+
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(strings.Trim(p, "/"), "/")
+	if !dirOnly && matchParts(patternParts, pathParts) {
+		return true
+	}
+	if strings.ContainsAny(patternParts[len(patternParts)-1], "*?[") {
+		return false
+	}
+	// Try the pattern against every directory the path is in.
+	for i := 1; i < len(pathParts); i++ {
+		if matchParts(patternParts, pathParts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match the components of a path against those of a pattern, where a "**"
+// component matches any number of path components.
+func matchParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], parts[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		parts = parts[1:]
+	}
+	return len(parts) == 0
+}
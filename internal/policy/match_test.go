@@ -0,0 +1,43 @@
+package policy
+
+import "testing"
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"*", "README.md", true},
+		{"*", "src/main.go", true},
+		{"*.go", "main.go", true},
+		{"*.go", "src/cmd/main.go", true},
+		{"*.go", "main.go.orig", false},
+		{"deploy/", "deploy/app.yaml", true},
+		{"deploy/", "deploy/prod/app.yaml", true},
+		{"deploy/", "services/deploy/app.yaml", true},
+		{"deploy/", "deploy", false},
+		{"deploy", "deploy", true},
+		{"deploy", "deploy/app.yaml", true},
+		{"/deploy/", "services/deploy/app.yaml", false},
+		{"/apps/web", "apps/web/index.html", true},
+		{"apps/web", "src/apps/web/index.html", false},
+		{"docs/*", "docs/index.md", true},
+		{"docs/*", "docs/guides/index.md", false},
+		{"docs/**", "docs/guides/index.md", true},
+		{"/docs/*.md", "docs/index.md", true},
+		{"/docs/*.md", "docs/index.txt", false},
+		{"**/logs", "build/logs/out.log", true},
+		{"src/**/test", "src/a/b/test/x_test.go", true},
+		{"src/**/test", "src/test/x_test.go", true},
+		{"?.txt", "a.txt", true},
+		{"?.txt", "ab.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if matched := matchPath(tt.pattern, tt.path); matched != tt.expected {
+				t.Errorf("matchPath returned %v, expected %v", matched, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+/*
+	A signing policy restricts which allowed signers may sign changes to
+	paths and references, in the style of a CODEOWNERS file:
+
+		# Groups of principals, referred to as @name.
+		group sre alice@example.com bob@example.com
+		group release *@release.example.com
+
+		# Paths, matched as in CODEOWNERS: the last matching rule applies.
+		*              *@example.com
+		deploy/        @sre
+		/docs/*.md
+
+		# References, matched as OpenSSH patterns: the last matching rule
+		# applies.
+		ref refs/tags/v*  @release
+
+	Each line holds a path pattern, or a "ref" or "group" keyword, followed
+	by space-separated owners. An owner is an OpenSSH style principal
+	pattern, or a group. A rule without owners lifts the restrictions of the
+	rules before it. A path starting with a keyword must be written with a
+	leading '/'.
+
+	A commit is authorized if its signer is one of the owners of each path it
+	changes, and of the reference it is pushed to, if known. A tag is
+	authorized if its signer is one of the owners of the tag reference.
+	Empty lines and lines starting with a '#' are ignored.
+*/
+
+var ErrUnauthorized = errors.New("signer is not authorized by the policy")
+
+type Policy struct {
+	Groups map[string][]string
+	Paths  []Rule
+	Refs   []Rule
+}
+
+// A rule of the policy: the owners of the paths or references that match the
+// pattern.
+type Rule struct {
+	Pattern string
+	Owners  []string
+	// The line of the policy file the rule was read from.
+	Line int
+}
+
+// Parse the given policy file.
+func Load(f string) (*Policy, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file, f)
+}
+
+// Parse the policy read from r. The name is used to identify the source of
+// any errors.
+func Parse(r io.Reader, name string) (*Policy, error) {
+	p := &Policy{Groups: map[string][]string{}}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "group":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("%s:%d: expected 'group <name> <principal>...'", name, lineNumber)
+			}
+			p.Groups[fields[1]] = append(p.Groups[fields[1]], fields[2:]...)
+		case "ref":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: expected 'ref <pattern> <owner>...'", name, lineNumber)
+			}
+			p.Refs = append(p.Refs, Rule{Pattern: fields[1], Owners: fields[2:], Line: lineNumber})
+		default:
+			p.Paths = append(p.Paths, Rule{Pattern: fields[0], Owners: fields[1:], Line: lineNumber})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Groups may be defined after the rules that use them.
+	for _, rules := range [][]Rule{p.Paths, p.Refs} {
+		for _, rule := range rules {
+			for _, owner := range rule.Owners {
+				if group, ok := strings.CutPrefix(owner, "@"); ok && p.Groups[group] == nil {
+					return nil, fmt.Errorf("%s:%d: unknown group '%s'", name, rule.Line, group)
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// Returns the last rule that applies to the path, if any.
+func (p *Policy) PathRule(path string) (Rule, bool) {
+	for i := len(p.Paths) - 1; i >= 0; i-- {
+		if matchPath(p.Paths[i].Pattern, path) {
+			return p.Paths[i], true
+		}
+	}
+	return Rule{}, false
+}
+
+// Returns the last rule that applies to the reference, if any.
+func (p *Policy) RefRule(ref string) (Rule, bool) {
+	for i := len(p.Refs) - 1; i >= 0; i-- {
+		if verify.MatchPatternList(ref, []string{p.Refs[i].Pattern}) {
+			return p.Refs[i], true
+		}
+	}
+	return Rule{}, false
+}
+
+// Check that the allowed signer, verified as the principal, may sign changes
+// to the reference and paths. Either may be empty if unknown.
+func (p *Policy) Check(signer verify.AllowedSigner, principal, ref string, paths []string) error {
+	principals := signerPrincipals(signer, principal)
+
+	if ref != "" {
+		if rule, ok := p.RefRule(ref); ok && !p.authorizes(rule, principals) {
+			return fmt.Errorf("%w: %s requires %s", ErrUnauthorized, ref, strings.Join(rule.Owners, " "))
+		}
+	}
+	for _, path := range paths {
+		if rule, ok := p.PathRule(path); ok && !p.authorizes(rule, principals) {
+			return fmt.Errorf("%w: %s requires %s", ErrUnauthorized, path, strings.Join(rule.Owners, " "))
+		}
+	}
+	return nil
+}
+
+// Returns true if one of the principals is an owner of the rule, or the rule
+// has no owners.
+func (p *Policy) authorizes(rule Rule, principals []string) bool {
+	if len(rule.Owners) == 0 {
+		return true
+	}
+
+	var patterns []string
+	for _, owner := range rule.Owners {
+		if group, ok := strings.CutPrefix(owner, "@"); ok {
+			patterns = append(patterns, p.Groups[group]...)
+		} else {
+			patterns = append(patterns, owner)
+		}
+	}
+	for _, principal := range principals {
+		if verify.MatchPatternList(principal, patterns) {
+			return true
+		}
+	}
+	return false
+}
+
+// The principals the signer is known as: the principal it was verified as
+// and, unless it is a certificate authority, whose principals are patterns
+// for the principals of the certificates it issues, its other principals.
+func signerPrincipals(signer verify.AllowedSigner, principal string) []string {
+	principals := []string{principal}
+	if signer.Options.CertAuthority {
+		return principals
+	}
+	for _, p := range signer.Principals {
+		if p != principal && !strings.ContainsAny(p, "*?!") {
+			principals = append(principals, p)
+		}
+	}
+	return principals
+}
+
+// Apply the policy to the verification of a commit or tag pushed to the
+// reference, if known. The paths are those the commit changes. A good
+// signature by a signer the policy does not authorize is marked as
+// unauthorized.
+func (p *Policy) Apply(v *gitobj.Verification, ref string, paths []string) {
+	if v.Status != verify.StatusGood || v.Signer == nil {
+		return
+	}
+	if err := p.Check(*v.Signer, v.Principal, ref, paths); err != nil {
+		v.Fail(verify.StatusUnauthorized, err)
+	}
+}
+
+// Returns true if the policy has rules for paths, so that the paths changed
+// by commits need to be listed.
+func (p *Policy) HasPathRules() bool {
+	return len(p.Paths) > 0
+}
+
+// Lists the paths a commit changes, e.g. a gitobj.DiffTree.
+type PathLister interface {
+	ChangedPaths(commit *gitobj.Object) ([]string, error)
+}
+
+// Apply the policy to the verification of a commit or tag in the repository,
+// listing the paths a commit changes if the policy has rules for paths. The
+// reference of a tag defaults to its tag name. If the paths cannot be
+// listed, the verification is marked as an error.
+func (p *Policy) ApplyObject(lister PathLister, obj *gitobj.Object, v *gitobj.Verification, ref string) {
+	if v.Status != verify.StatusGood {
+		return
+	}
+
+	if name, ok := obj.Header("tag"); ok && ref == "" && obj.Type == gitobj.TypeTag {
+		ref = "refs/tags/" + name
+	}
+	var paths []string
+	if obj.Type == gitobj.TypeCommit && p.HasPathRules() {
+		var err error
+		paths, err = lister.ChangedPaths(obj)
+		if err != nil {
+			v.Fail(verify.StatusError, err)
+			return
+		}
+	}
+	p.Apply(v, ref, paths)
+}
@@ -0,0 +1,178 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+const testPolicy = `# Signing policy
+group sre alice@example.com bob@example.com
+group release *@release.example.com
+
+*              *@example.com *@release.example.com
+deploy/        @sre
+/docs/
+ref refs/tags/v*  @release
+ref refs/heads/main @sre carol@example.com
+`
+
+func TestParse(t *testing.T) {
+	p, err := Parse(strings.NewReader(testPolicy), "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Policy{
+		Groups: map[string][]string{
+			"sre":     {"alice@example.com", "bob@example.com"},
+			"release": {"*@release.example.com"},
+		},
+		Paths: []Rule{
+			{Pattern: "*", Owners: []string{"*@example.com", "*@release.example.com"}, Line: 5},
+			{Pattern: "deploy/", Owners: []string{"@sre"}, Line: 6},
+			{Pattern: "/docs/", Owners: []string{}, Line: 7},
+		},
+		Refs: []Rule{
+			{Pattern: "refs/tags/v*", Owners: []string{"@release"}, Line: 8},
+			{Pattern: "refs/heads/main", Owners: []string{"@sre", "carol@example.com"}, Line: 9},
+		},
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Parse returned %+v, expected %+v", p, expected)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"group without members": "group sre\n",
+		"ref without pattern":   "ref\n",
+		"unknown group":         "deploy/ @sre\n",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(input), "policy"); err == nil || !strings.HasPrefix(err.Error(), "policy:1: ") {
+				t.Errorf("Parse returned %v, expected an error for line 1", err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "policy")
+	if err := os.WriteFile(f, []byte(testPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Paths) != 3 || len(p.Refs) != 2 || !p.HasPathRules() {
+		t.Errorf("Load returned %+v", p)
+	}
+
+	if _, err := Load(f + ".missing"); err == nil {
+		t.Error("Load returned no error for a missing file")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, err := Parse(strings.NewReader(testPolicy), "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := func(principals ...string) verify.AllowedSigner {
+		return verify.AllowedSigner{Email: strings.Join(principals, ","), Principals: principals}
+	}
+
+	tests := []struct {
+		name       string
+		signer     verify.AllowedSigner
+		principal  string
+		ref        string
+		paths      []string
+		authorized bool
+	}{
+		{"any path", signer("dave@example.com"), "dave@example.com", "", []string{"src/main.go"}, true},
+		{"unowned path", signer("eve@elsewhere.com"), "eve@elsewhere.com", "", []string{"src/main.go"}, false},
+		{"owner of every path", signer("alice@example.com"), "alice@example.com", "", []string{"src/main.go", "deploy/app.yaml"}, true},
+		{"not an owner of a path", signer("dave@example.com"), "dave@example.com", "", []string{"src/main.go", "deploy/app.yaml"}, false},
+		{"rule without owners", signer("eve@elsewhere.com"), "eve@elsewhere.com", "", []string{"docs/index.md"}, true},
+		{"other principal of the signer", signer("dave@example.com", "bob@example.com"), "dave@example.com", "", []string{"deploy/app.yaml"}, true},
+		{"release tag", signer("ci@release.example.com"), "ci@release.example.com", "refs/tags/v1.0.0", nil, true},
+		{"tag not by release", signer("alice@example.com"), "alice@example.com", "refs/tags/v1.0.0", nil, false},
+		{"unrestricted ref", signer("dave@example.com"), "dave@example.com", "refs/heads/feature", []string{"README.md"}, true},
+		{"ref owner", signer("carol@example.com"), "carol@example.com", "refs/heads/main", []string{"README.md"}, true},
+		{"not a ref owner", signer("dave@example.com"), "dave@example.com", "refs/heads/main", []string{"README.md"}, false},
+		{
+			name: "certificate authority",
+			signer: verify.AllowedSigner{
+				Principals: []string{"*@example.com"},
+				Options:    verify.SignerOptions{CertAuthority: true},
+			},
+			principal:  "dave@example.com",
+			paths:      []string{"deploy/app.yaml"},
+			authorized: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.signer, tt.principal, tt.ref, tt.paths)
+			if tt.authorized && err != nil {
+				t.Errorf("Check returned %v, expected no error", err)
+			}
+			if !tt.authorized && !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("Check returned %v, expected %v", err, ErrUnauthorized)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	p, err := Parse(strings.NewReader(testPolicy), "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good := func(principal string) *gitobj.Verification {
+		return &gitobj.Verification{
+			Object: "abc",
+			Type:   gitobj.TypeCommit,
+			Result: &verify.Result{
+				Status:    verify.StatusGood,
+				Principal: principal,
+				Signer:    &verify.AllowedSigner{Principals: []string{principal}},
+			},
+		}
+	}
+
+	v := good("dave@example.com")
+	p.Apply(v, "", []string{"deploy/app.yaml"})
+	if v.Status != verify.StatusUnauthorized || v.Principal != "dave@example.com" {
+		t.Errorf("Apply returned %+v, expected an unauthorized signature by dave@example.com", v.Result)
+	}
+	expected := "signer is not authorized by the policy: deploy/app.yaml requires @sre"
+	if v.Reason != expected || !errors.Is(v.Err, ErrUnauthorized) {
+		t.Errorf("Apply returned reason %q, expected %q", v.Reason, expected)
+	}
+
+	v = good("alice@example.com")
+	p.Apply(v, "", []string{"deploy/app.yaml"})
+	if v.Status != verify.StatusGood {
+		t.Errorf("Apply returned %s for an owner", v.Status)
+	}
+
+	// Only good signatures are checked against the policy.
+	v = &gitobj.Verification{Result: &verify.Result{Status: verify.StatusUnknownSigner}}
+	p.Apply(v, "", []string{"deploy/app.yaml"})
+	if v.Status != verify.StatusUnknownSigner {
+		t.Errorf("Apply returned %s for an unknown signer", v.Status)
+	}
+}
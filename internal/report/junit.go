@@ -0,0 +1,81 @@
+package report
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Type    string `xml:"type,attr,omitempty"`
+	Message string `xml:"message,attr"`
+}
+
+// Write the run as JUnit XML, with a test case for each object. Failures
+// are reported as failed test cases, and objects with other statuses than
+// good, e.g. unsigned commits, as skipped test cases. The test cases of
+// objects pushed to a reference are named after the reference, too.
+func WriteJUnit(w io.Writer, run Run) error {
+	suite := junitTestSuite{Name: run.title()}
+	for _, r := range run.Results {
+		name := r.Object
+		if r.Ref != "" {
+			name = r.Ref + " " + r.Object
+		}
+		tc := junitTestCase{Name: name, ClassName: r.Type}
+		msg := &junitMessage{Type: string(r.Status), Message: r.Reason}
+		if r.Failed {
+			tc.Failure = msg
+			suite.Failures++
+		} else if r.Status != verify.StatusGood {
+			tc.Skipped = msg
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Tests = len(suite.Cases)
+
+	doc := junitTestSuites{
+		Name:     run.Name,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
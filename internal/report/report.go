@@ -0,0 +1,56 @@
+// Package report writes the verifications of commits, tags and push
+// certificates in the formats of other tools, such as the SARIF of code
+// scanning dashboards and the JUnit XML of CI servers, so that unsigned and
+// invalid objects show up next to the findings of other checks.
+package report
+
+import (
+	"fmt"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+)
+
+// The verifications of a run of ssh-sign, e.g. an audit of a revision range,
+// or of the objects a push introduces.
+type Run struct {
+	// The name of the command, e.g. "ssh-sign audit".
+	Name string
+	// What was verified, e.g. the revision range of an audit.
+	Target  string
+	Results []Result
+}
+
+// A verification and whether the run counts it as a failure. Verifications
+// that are not good, but are not failures, e.g. unsigned commits in an
+// audit that does not fail on them, are reported as warnings or skipped.
+type Result struct {
+	*gitobj.Verification
+	// The reference the object was pushed to, if any.
+	Ref    string
+	Failed bool
+}
+
+// Describe the object of the verification, e.g. "commit abc", or
+// "mergetag def in commit abc" for a tag merged by a commit.
+func Describe(v *gitobj.Verification) string {
+	if v.Commit != "" {
+		return fmt.Sprintf("%s %s in commit %s", v.Type, v.Object, v.Commit)
+	}
+	return fmt.Sprintf("%s %s", v.Type, v.Object)
+}
+
+// Describe the object of the result, prefixed by the reference it was pushed
+// to, if any.
+func (r Result) describe() string {
+	if r.Ref != "" {
+		return r.Ref + ": " + Describe(r.Verification)
+	}
+	return Describe(r.Verification)
+}
+
+func (r Run) title() string {
+	if r.Target == "" {
+		return r.Name
+	}
+	return r.Name + " " + r.Target
+}
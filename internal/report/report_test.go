@@ -0,0 +1,218 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+// A run of a hook, with a good commit, an unsigned commit that is accepted,
+// a rejected tag, and a rejected tag merged by a commit.
+func testRun() Run {
+	return Run{
+		Name:   "ssh-sign hook pre-receive",
+		Target: "refs/heads/main refs/tags/v1",
+		Results: []Result{
+			{
+				Verification: &gitobj.Verification{Object: "aaa", Type: "commit", Result: &verify.Result{Status: verify.StatusGood, Principal: "alice@example.com"}},
+				Ref:          "refs/heads/main",
+			},
+			{
+				Verification: &gitobj.Verification{Object: "bbb", Type: "commit", Result: &verify.Result{Status: verify.StatusUnsigned, Reason: "no signature found"}},
+				Ref:          "refs/heads/main",
+			},
+			{
+				Verification: &gitobj.Verification{Object: "ccc", Type: "tag", Result: &verify.Result{Status: verify.StatusRevoked, Reason: "key is revoked: SHA256:abc", Fingerprint: "SHA256:abc"}},
+				Ref:          "refs/tags/v1",
+				Failed:       true,
+			},
+			{
+				Verification: &gitobj.Verification{Object: "ddd", Type: "mergetag", Commit: "aaa", Result: &verify.Result{Status: verify.StatusUnknownSigner, Reason: "no principal matched"}},
+				Failed:       true,
+			},
+		},
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	run := testRun()
+	for i, expected := range []string{
+		"refs/heads/main: commit aaa",
+		"refs/heads/main: commit bbb",
+		"refs/tags/v1: tag ccc",
+		"mergetag ddd in commit aaa",
+	} {
+		if d := run.Results[i].describe(); d != expected {
+			t.Errorf("describe returned %q, expected %q", d, expected)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteJUnit(&b, testRun()); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded junitTestSuites
+	if err := xml.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "ssh-sign hook pre-receive" || decoded.Tests != 4 || decoded.Failures != 2 || decoded.Skipped != 1 {
+		t.Errorf("WriteJUnit returned %s with %d tests, %d failures and %d skipped", decoded.Name, decoded.Tests, decoded.Failures, decoded.Skipped)
+	}
+
+	suite := decoded.Suites[0]
+	if suite.Name != "ssh-sign hook pre-receive refs/heads/main refs/tags/v1" || suite.Tests != 4 {
+		t.Errorf("WriteJUnit returned test suite %s with %d tests", suite.Name, suite.Tests)
+	}
+	cases := suite.Cases
+	if cases[0].Name != "refs/heads/main aaa" || cases[0].Failure != nil || cases[0].Skipped != nil {
+		t.Errorf("WriteJUnit returned %+v for a good signature", cases[0])
+	}
+	if cases[1].Skipped == nil || cases[1].Skipped.Type != "unsigned" {
+		t.Errorf("WriteJUnit returned %+v for an accepted unsigned commit", cases[1])
+	}
+	if cases[2].Failure == nil || cases[2].Failure.Message != "key is revoked: SHA256:abc" || cases[2].ClassName != "tag" {
+		t.Errorf("WriteJUnit returned %+v for a revoked key", cases[2])
+	}
+	if cases[3].Name != "ddd" || cases[3].ClassName != "mergetag" || cases[3].Failure == nil {
+		t.Errorf("WriteJUnit returned %+v for a merged tag", cases[3])
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteSARIF(&b, testRun()); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Schema  string `json:"$schema"`
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string
+					Rules []struct {
+						ID                   string
+						DefaultConfiguration struct{ Level string }
+					}
+				}
+			}
+			AutomationDetails struct{ Description struct{ Text string } }
+			Results           []struct {
+				RuleID    string
+				RuleIndex int
+				Level     string
+				Message   struct{ Text string }
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine int }
+					}
+					LogicalLocations []struct {
+						Name               string
+						FullyQualifiedName string
+						Kind               string
+					}
+				}
+				PartialFingerprints map[string]string
+				Properties          map[string]interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	// The fields that code scanning requires of a log.
+	if decoded.Version != "2.1.0" || decoded.Schema == "" || len(decoded.Runs) != 1 {
+		t.Fatalf("WriteSARIF returned\n%s", b.String())
+	}
+
+	run := decoded.Runs[0]
+	if run.Tool.Driver.Name != "ssh-sign" || run.AutomationDetails.Description.Text != "ssh-sign hook pre-receive refs/heads/main refs/tags/v1" {
+		t.Errorf("WriteSARIF returned tool %s for %s", run.Tool.Driver.Name, run.AutomationDetails.Description.Text)
+	}
+
+	// Every status but good is a rule.
+	rules := run.Tool.Driver.Rules
+	if len(rules) != len(sarifRules) {
+		t.Fatalf("WriteSARIF returned %d rules, expected %d", len(rules), len(sarifRules))
+	}
+	for _, r := range rules {
+		if r.ID == string(verify.StatusGood) {
+			t.Error("WriteSARIF returned a rule for good signatures")
+		}
+	}
+
+	if len(run.Results) != 3 {
+		t.Fatalf("WriteSARIF returned %d results, expected one for each object that is not good", len(run.Results))
+	}
+	for i, tt := range []struct {
+		ruleID  string
+		level   string
+		message string
+		fqn     string
+		kind    string
+	}{
+		{"unsigned", "warning", "refs/heads/main: commit bbb: unsigned: no signature found", "refs/heads/main/bbb", "commit"},
+		{"revoked", "error", "refs/tags/v1: tag ccc: revoked: key is revoked: SHA256:abc", "refs/tags/v1/ccc", "tag"},
+		{"unknown-signer", "error", "mergetag ddd in commit aaa: unknown-signer: no principal matched", "aaa/ddd", "mergetag"},
+	} {
+		r := run.Results[i]
+		if r.RuleID != tt.ruleID || rules[r.RuleIndex].ID != tt.ruleID || r.Level != tt.level || r.Message.Text != tt.message {
+			t.Errorf("result %d is %s (rule %d), %s: %q", i, r.RuleID, r.RuleIndex, r.Level, r.Message.Text)
+		}
+		// Code scanning requires a physical location of each result.
+		if len(r.Locations) != 1 {
+			t.Fatalf("result %d has %d locations", i, len(r.Locations))
+		}
+		physical := r.Locations[0].PhysicalLocation
+		if physical.ArtifactLocation.URI != tt.fqn || physical.Region.StartLine != 1 {
+			t.Errorf("result %d is located at %+v", i, physical)
+		}
+		loc := r.Locations[0].LogicalLocations[0]
+		if loc.FullyQualifiedName != tt.fqn || loc.Kind != tt.kind {
+			t.Errorf("result %d is located at %+v", i, loc)
+		}
+	}
+
+	// The pseudo-path of an object is escaped as a URI.
+	r := Result{Verification: &gitobj.Verification{Object: "eee", Type: "commit", Result: &verify.Result{Status: verify.StatusUnsigned}}, Ref: "refs/heads/fix#1-100%"}
+	if uri := sarifResultFor(r).Locations[0].PhysicalLocation.ArtifactLocation.URI; uri != "refs/heads/fix%231-100%25/eee" {
+		t.Errorf("sarifResultFor returned URI %s", uri)
+	}
+
+	revoked := run.Results[1]
+	if revoked.PartialFingerprints["objectId/v1"] != "ccc" {
+		t.Errorf("WriteSARIF returned fingerprints %v", revoked.PartialFingerprints)
+	}
+	if revoked.Properties["ref"] != "refs/tags/v1" || revoked.Properties["status"] != "revoked" || revoked.Properties["fingerprint"] != "SHA256:abc" {
+		t.Errorf("WriteSARIF returned properties %v", revoked.Properties)
+	}
+}
+
+func TestWriteSARIFNoResults(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteSARIF(&b, Run{Name: "ssh-sign audit", Target: "HEAD"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run without results still lists its results, as an empty array.
+	var decoded struct {
+		Runs []struct {
+			Results []interface{}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Runs[0].Results == nil || len(decoded.Runs[0].Results) != 0 {
+		t.Errorf("WriteSARIF returned\n%s", b.String())
+	}
+}
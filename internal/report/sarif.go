@@ -0,0 +1,218 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/gitobj"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+)
+
+/*
+	SARIF is the Static Analysis Results Interchange Format, version 2.1.0 of
+	which is specified at https://docs.oasis-open.org/sarif/sarif/v2.1.0/.
+	Each status other than good is a rule, with the status as its ID, and
+	each object that is not good a result of the rule. Objects are not files,
+	so results are located by the logical location of the object, e.g. a
+	commit. Code scanning requires a physical location, too, so the object
+	is also located at a pseudo-path of the same name, e.g.
+	refs/tags/v1/<tag id>, on its first line.
+*/
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	toolName     = "ssh-sign"
+	toolURI      = "https://github.com/Keeper-Security/git-ssh-sign"
+)
+
+// The SARIF result levels.
+const (
+	levelError   = "error"
+	levelWarning = "warning"
+)
+
+type sarifRuleInfo struct {
+	status      verify.Status
+	name        string
+	description string
+	level       string
+}
+
+// A rule for each failure class, i.e. each status other than good. Objects
+// that are unsigned, or not signed with SSH, are warnings by default, as the
+// audit does not fail on them unless told to.
+var sarifRules = []sarifRuleInfo{
+	{verify.StatusUnsigned, "UnsignedObject", "The commit or tag is not signed.", levelWarning},
+	{verify.StatusNonSSH, "NonSSHSignature", "The commit or tag has an OpenPGP or X.509 signature, rather than an SSH signature.", levelWarning},
+	{verify.StatusBadSignature, "BadSignature", "The signature does not match the signed data or namespace.", levelError},
+	{verify.StatusUnknownSigner, "UnknownSigner", "The key is not authorized by any allowed signer.", levelError},
+	{verify.StatusExpired, "ExpiredKey", "The key or certificate was not valid at the time of the signature.", levelError},
+	{verify.StatusRevoked, "RevokedKey", "The key, certificate or certificate authority has been revoked.", levelError},
+	{verify.StatusNotAllowed, "SignerNotAllowed", "The key is authorized, but not for the namespace or a permitted principal, or the security key signature lacks a required flag.", levelError},
+	{verify.StatusUnauthorized, "UnauthorizedSigner", "The signer is not authorized by the signing policy for the reference or the paths that were changed.", levelError},
+	{verify.StatusError, "VerificationError", "The object or its signature could not be read or parsed.", levelError},
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool              sarifTool              `json:"tool"`
+	AutomationDetails sarifAutomationDetails `json:"automationDetails"`
+	Results           []sarifResult          `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifAutomationDetails struct {
+	Description sarifMessage `json:"description"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          sarifProperties   `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// The verification of a result, as in the JSON output of ssh-sign, and the
+// reference the object was pushed to.
+type sarifProperties struct {
+	Ref string `json:"ref,omitempty"`
+	*gitobj.Verification
+}
+
+// Write the run as a SARIF 2.1.0 log, with a result for each object that is
+// not good. Failures are errors, and other objects, e.g. unsigned commits
+// that the run does not fail on, warnings.
+func WriteSARIF(w io.Writer, run Run) error {
+	driver := sarifDriver{Name: toolName, InformationURI: toolURI, Rules: []sarifRule{}}
+	for _, rule := range sarifRules {
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   string(rule.status),
+			Name:                 rule.name,
+			ShortDescription:     sarifMessage{Text: rule.description},
+			DefaultConfiguration: sarifConfiguration{Level: rule.level},
+		})
+	}
+
+	sr := sarifRun{
+		Tool:              sarifTool{Driver: driver},
+		AutomationDetails: sarifAutomationDetails{Description: sarifMessage{Text: run.title()}},
+		Results:           []sarifResult{},
+	}
+	for _, r := range run.Results {
+		if r.Status == verify.StatusGood {
+			continue
+		}
+		sr.Results = append(sr.Results, sarifResultFor(r))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []sarifRun{sr}})
+}
+
+func sarifResultFor(r Result) sarifResult {
+	index := sarifRuleIndex(r.Status)
+	level := levelWarning
+	if r.Failed {
+		level = levelError
+	}
+
+	name := r.Object
+	if r.Commit != "" {
+		name = r.Commit + "/" + r.Object
+	}
+	if r.Ref != "" {
+		name = r.Ref + "/" + name
+	}
+
+	return sarifResult{
+		RuleID:    string(sarifRules[index].status),
+		RuleIndex: index,
+		Level:     level,
+		Message:   sarifMessage{Text: r.describe() + ": " + string(r.Status) + ": " + r.Reason},
+		Locations: []sarifLocation{{
+			PhysicalLocation: sarifPhysicalLocation{
+				// References may contain characters, e.g. '#', that end
+				// the path of a URI.
+				ArtifactLocation: sarifArtifactLocation{URI: (&url.URL{Path: name}).EscapedPath()},
+				Region:           sarifRegion{StartLine: 1},
+			},
+			LogicalLocations: []sarifLogicalLocation{{
+				Name:               r.Object,
+				FullyQualifiedName: name,
+				Kind:               r.Type,
+			}},
+		}},
+		PartialFingerprints: map[string]string{"objectId/v1": r.Object},
+		Properties:          sarifProperties{Ref: r.Ref, Verification: r.Verification},
+	}
+}
+
+// Returns the index of the rule of the status. Statuses without a rule are
+// reported as verification errors.
+func sarifRuleIndex(status verify.Status) int {
+	for i, rule := range sarifRules {
+		if rule.status == status {
+			return i
+		}
+	}
+	return sarifRuleIndex(verify.StatusError)
+}
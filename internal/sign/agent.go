@@ -0,0 +1,132 @@
+package sign
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	ErrNoAgent              = errors.New("SSH_AUTH_SOCK is not set; is ssh-agent running?")
+	ErrAgentKeyNotFound     = errors.New("key not found in ssh-agent")
+	ErrAgentKeyNotGiven     = errors.New("no key given to sign with from ssh-agent")
+	errUnsupportedAlgorithm = errors.New("ssh-agent does not support the signature algorithm")
+)
+
+// Connect to the ssh-agent listening on the Unix socket at the given path,
+// or at SSH_AUTH_SOCK if the path is empty. The connection must be closed
+// when done.
+func DialAgent(path string) (agent.ExtendedAgent, io.Closer, error) {
+	if path == "" {
+		path = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if path == "" {
+		return nil, nil, ErrNoAgent
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to ssh-agent: %w", err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// A key held by ssh-agent. The private key never leaves the agent, which
+// is asked to sign instead.
+type agentSigner struct {
+	agent agent.ExtendedAgent
+	key   ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.key
+}
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+// Ask the agent to sign the data. RSA keys are signed with SHA-256 or
+// SHA-512, rather than SHA-1, by setting the flag for the algorithm.
+func (s *agentSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case "", s.key.Type():
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedAlgorithm, algorithm)
+	}
+	return s.agent.SignWithFlags(s.key, data, flags)
+}
+
+// Find the key of the agent to sign with, given as a public key in
+// authorized_keys format, e.g. "ssh-ed25519 AAAA... comment", or as its
+// SHA256 fingerprint.
+func AgentSigner(a agent.ExtendedAgent, key string) (ssh.AlgorithmSigner, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, ErrAgentKeyNotGiven
+	}
+
+	match := func(k ssh.PublicKey) bool { return ssh.FingerprintSHA256(k) == key }
+	if !strings.HasPrefix(key, "SHA256:") {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		match = func(k ssh.PublicKey) bool {
+			return k.Type() == pub.Type() && string(k.Marshal()) == string(pub.Marshal())
+		}
+	}
+
+	keys, err := a.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing ssh-agent keys: %w", err)
+	}
+	for _, k := range keys {
+		if match(k) {
+			return &agentSigner{agent: a, key: k}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrAgentKeyNotFound, key)
+}
+
+// Provides signers for keys held by the ssh-agent at SSH_AUTH_SOCK, given
+// as public keys or fingerprints, as for AgentSigner. The agent is connected
+// to on first use, and the connection kept until the provider is closed.
+type AgentProvider struct {
+	// The path of the socket of the agent. Defaults to SSH_AUTH_SOCK.
+	Socket string
+	agent  agent.ExtendedAgent
+	conn   io.Closer
+}
+
+func (p *AgentProvider) Signer(key string) (ssh.AlgorithmSigner, error) {
+	if p.agent == nil {
+		a, conn, err := DialAgent(p.Socket)
+		if err != nil {
+			return nil, err
+		}
+		p.agent, p.conn = a, conn
+	}
+	return AgentSigner(p.agent, key)
+}
+
+// Close the connection to the agent, if one was made.
+func (p *AgentProvider) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.agent, p.conn = nil, nil
+	return err
+}
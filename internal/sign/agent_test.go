@@ -0,0 +1,207 @@
+package sign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Serve a keyring holding the test keys on a Unix socket, as ssh-agent
+// does, and return the path of the socket.
+func newTestAgent(t *testing.T) string {
+	t.Helper()
+	keyring := agent.NewKeyring()
+	for _, pem := range []string{ed25519PrivateKey, rsaPrivateKey} {
+		key, err := ssh.ParseRawPrivateKey([]byte(pem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	return path
+}
+
+func dialTestAgent(t *testing.T, path string) agent.ExtendedAgent {
+	t.Helper()
+	a, conn, err := DialAgent(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return a
+}
+
+func publicKey(t *testing.T, pem string) ssh.PublicKey {
+	t.Helper()
+	s, err := ssh.ParsePrivateKey([]byte(pem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.PublicKey()
+}
+
+func TestDialAgent(t *testing.T) {
+	path := newTestAgent(t)
+
+	t.Setenv("SSH_AUTH_SOCK", path)
+	a, conn, err := DialAgent("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if keys, err := a.List(); err != nil || len(keys) != 2 {
+		t.Errorf("List returned %d keys, %v", len(keys), err)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, _, err := DialAgent(""); !errors.Is(err, ErrNoAgent) {
+		t.Errorf("DialAgent returned %v without SSH_AUTH_SOCK", err)
+	}
+	if _, _, err := DialAgent(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Error("DialAgent returned no error for a missing socket")
+	}
+}
+
+func TestAgentSigner(t *testing.T) {
+	a := dialTestAgent(t, newTestAgent(t))
+	ed25519Key := publicKey(t, ed25519PrivateKey)
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ed25519Key)))
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr error
+	}{
+		{name: "public key", key: authorized},
+		{name: "public key with comment", key: authorized + " test@example.com\n"},
+		{name: "fingerprint", key: ssh.FingerprintSHA256(ed25519Key)},
+		{name: "unknown fingerprint", key: "SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU", wantErr: ErrAgentKeyNotFound},
+		{name: "unknown key", key: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(otherKey))), wantErr: ErrAgentKeyNotFound},
+		{name: "empty", key: " ", wantErr: ErrAgentKeyNotGiven},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := AgentSigner(a, tt.key)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("AgentSigner returned %v, expected %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ssh.FingerprintSHA256(s.PublicKey()) != ssh.FingerprintSHA256(ed25519Key) {
+				t.Errorf("AgentSigner returned key %s", ssh.FingerprintSHA256(s.PublicKey()))
+			}
+		})
+	}
+
+	if _, err := AgentSigner(a, "not a key"); err == nil || errors.Is(err, ErrAgentKeyNotFound) {
+		t.Errorf("AgentSigner returned %v for an invalid key", err)
+	}
+}
+
+func TestAgentProvider(t *testing.T) {
+	p := &AgentProvider{Socket: newTestAgent(t)}
+	defer p.Close()
+
+	for _, tt := range []struct {
+		name      string
+		pem       string
+		algorithm string
+	}{
+		{name: "ED25519", pem: ed25519PrivateKey, algorithm: ssh.KeyAlgoED25519},
+		// RSA keys are signed with SHA-512, as ssh-keygen does.
+		{name: "RSA", pem: rsaPrivateKey, algorithm: ssh.KeyAlgoRSASHA512},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			key := publicKey(t, tt.pem)
+			s, err := p.Signer(ssh.FingerprintSHA256(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			armored, err := Sign(s, DefaultNamespace, sshsig.DefaultHashAlgorithm, strings.NewReader("test data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sig, err := sshsig.Unarmor(armored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sig.Signature.Format != tt.algorithm {
+				t.Errorf("Sign signed with %s, expected %s", sig.Signature.Format, tt.algorithm)
+			}
+			if err := sshsig.Verify(sig, key, DefaultNamespace, strings.NewReader("test data")); err != nil {
+				t.Errorf("signature does not verify: %v", err)
+			}
+		})
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p.Socket = filepath.Join(t.TempDir(), "missing.sock")
+	if _, err := p.Signer(ssh.FingerprintSHA256(publicKey(t, ed25519PrivateKey))); err == nil {
+		t.Error("Signer returned no error for a missing agent")
+	}
+}
+
+func TestAgentSignerAlgorithms(t *testing.T) {
+	a := dialTestAgent(t, newTestAgent(t))
+	s, err := AgentSigner(a, ssh.FingerprintSHA256(publicKey(t, rsaPrivateKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algorithm := range []string{ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512} {
+		sig, err := s.SignWithAlgorithm(nil, []byte("data"), algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Format != algorithm {
+			t.Errorf("SignWithAlgorithm signed with %s, expected %s", sig.Format, algorithm)
+		}
+	}
+	if _, err := s.SignWithAlgorithm(nil, []byte("data"), ssh.KeyAlgoED25519); err == nil {
+		t.Error("SignWithAlgorithm returned no error for an algorithm of another key type")
+	}
+}
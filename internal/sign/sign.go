@@ -1,96 +1,30 @@
 package sign
 
 import (
-	"crypto/rand"
-	"crypto/sha512"
-	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
+	"os"
 
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
 	"golang.org/x/crypto/ssh"
 )
 
-/*
-	The code in this module is heavily based on the great work done by the
-	sigstore/rekor project: https://github.com/sigstore/rekor/
-*/
+const DefaultNamespace = "git"
 
-// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig#L81
-type MessageWrapper struct {
-	Namespace     string
-	Reserved      string
-	HashAlgorithm string
-	Hash          string
-}
-
-// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig#L34
-type WrappedSig struct {
-	MagicHeader   [6]byte
-	Version       uint32
-	PublicKey     string
-	Namespace     string
-	Reserved      string
-	HashAlgorithm string
-	Signature     string
-}
-
-const (
-	MagicHeader          = "SSHSIG"
-	DefaultHashAlgorithm = "sha512"
-	Namespace            = "git"
-)
-
-// Create an Armored (PEM) Signature
-func Armor(sshSig *ssh.Signature, pubKey ssh.PublicKey) []byte {
-	sig := WrappedSig{
-		Version:       1,
-		PublicKey:     string(pubKey.Marshal()),
-		Namespace:     Namespace,
-		HashAlgorithm: DefaultHashAlgorithm,
-		Signature:     string(ssh.Marshal(sshSig)),
-	}
-
-	copy(sig.MagicHeader[:], MagicHeader)
-
-	enc := pem.EncodeToMemory(&pem.Block{
-		Type:  "SSH SIGNATURE",
-		Bytes: ssh.Marshal(sig),
-	})
-	return enc
-}
-
-// Create a signature for the given data using the given signer.
-func NewSignature(signer ssh.AlgorithmSigner, data io.Reader) (*ssh.Signature, error) {
-	hf := sha512.New()
-	if _, err := io.Copy(hf, data); err != nil {
-		return nil, err
-	}
-	mh := hf.Sum(nil)
-
-	sp := MessageWrapper{
-		Namespace:     Namespace,
-		HashAlgorithm: DefaultHashAlgorithm,
-		Hash:          string(mh),
-	}
-
-	dataMessageWrapper := ssh.Marshal(sp)
-	dataMessageWrapper = append([]byte(MagicHeader), dataMessageWrapper...)
-
-	// ssh-rsa is not supported for RSA keys:
-	// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig#L71
-	// We can use the default value of "" for other key types though.
-	algo := ""
-	if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
-		algo = ssh.KeyAlgoRSASHA512
-	}
-	sig, err := signer.SignWithAlgorithm(rand.Reader, dataMessageWrapper, algo)
+// Sign a commit(data), or any other data in the given namespace, using the
+// given private key.
+func SignCommit(sshPrivateKey string, passphrase string, namespace string, data io.Reader) ([]byte, error) {
+	s, err := ParsePrivateKey(sshPrivateKey, passphrase)
 	if err != nil {
 		return nil, err
 	}
-	return sig, nil
+	return Sign(s, namespace, sshsig.DefaultHashAlgorithm, data)
 }
 
-// Sign a commit(data) using the given private key.
-func SignCommit(sshPrivateKey string, passphrase string, data io.Reader) ([]byte, error) {
+// Parse a PEM encoded private key, decrypting it with the passphrase if one
+// is given.
+func ParsePrivateKey(sshPrivateKey string, passphrase string) (ssh.AlgorithmSigner, error) {
 	var err error
 	var s ssh.Signer
 	if passphrase != "" {
@@ -104,14 +38,36 @@ func SignCommit(sshPrivateKey string, passphrase string, data io.Reader) ([]byte
 
 	as, ok := s.(ssh.AlgorithmSigner)
 	if !ok {
+		return nil, errors.New("key does not support signing with a specific algorithm")
+	}
+	return as, nil
+}
+
+// Provides signers for private key files, given by their path. Keys
+// protected by a passphrase are not supported, as there is no terminal to
+// ask for it when git signs; load them into ssh-agent instead.
+type FileProvider struct{}
+
+func (FileProvider) Signer(path string) (ssh.AlgorithmSigner, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
 		return nil, err
 	}
+	s, err := ParsePrivateKey(string(b), "")
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%s is protected by a passphrase; load it into ssh-agent and sign with agent:// instead", path)
+	}
+	return s, err
+}
 
-	sig, err := NewSignature(as, data)
+// Sign the data in the namespace with the signer, hashing it with the hash
+// algorithm, "sha256" or "sha512", and armor the signature.
+func Sign(as ssh.AlgorithmSigner, namespace, hashAlg string, data io.Reader) ([]byte, error) {
+	sig, err := sshsig.Sign(as, namespace, hashAlg, data)
 	if err != nil {
 		return nil, err
 	}
 
-	armored := Armor(sig, s.PublicKey())
-	return armored, nil
+	return sshsig.Armor(sig), nil
 }
@@ -1,8 +1,16 @@
 package sign
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
+	"golang.org/x/crypto/ssh"
 )
 
 var (
@@ -87,7 +95,7 @@ func TestSignCommit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := SignCommit(tt.key, "", data)
+			_, err := SignCommit(tt.key, "", DefaultNamespace, data)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("TestSignCommit expected: %v, got: %v", tt.wantErr, err)
 				return
@@ -95,3 +103,79 @@ func TestSignCommit(t *testing.T) {
 		})
 	}
 }
+
+func TestSignCommitNamespace(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		namespace string
+		wantErr   bool
+	}{
+		{
+			name:      "git",
+			namespace: DefaultNamespace,
+			wantErr:   false,
+		},
+		{
+			name:      "file",
+			namespace: "file",
+			wantErr:   false,
+		},
+		{
+			name:      "empty",
+			namespace: "",
+			wantErr:   true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := SignCommit(ed25519PrivateKey, "", tt.namespace, strings.NewReader("test data"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TestSignCommitNamespace expected: %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := write("encrypted", string(pem.EncodeToMemory(block)))
+
+	for _, tt := range []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "ED25519 Key", path: write("ed25519", ed25519PrivateKey)},
+		{name: "RSA Key", path: write("rsa", rsaPrivateKey)},
+		{name: "Invalid Key", path: write("invalid", "invalid key"), wantErr: true},
+		{name: "Missing File", path: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "Passphrase", path: encrypted, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := FileProvider{}.Signer(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer returned %v, expected error: %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if _, err := Sign(s, DefaultNamespace, sshsig.DefaultHashAlgorithm, strings.NewReader("test data")); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+// Package testutil has helpers shared by the tests of other packages, to
+// generate keys and to write signed commits and tags to a repository. It
+// must only be imported by tests.
+package testutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
+	"golang.org/x/crypto/ssh"
+)
+
+// Generate a new ed25519 signer.
+func NewSigner(t testing.TB) ssh.AlgorithmSigner {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(ssh.AlgorithmSigner)
+}
+
+// Format a public key as it appears in the allowed signers file.
+func AuthorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// Sign the payload in the git namespace, returning the armored signature, if
+// a signer is given.
+func Sign(t testing.TB, signer ssh.AlgorithmSigner, payload string) string {
+	t.Helper()
+	if signer == nil {
+		return ""
+	}
+	sig, err := sshsig.Sign(signer, "git", sshsig.DefaultHashAlgorithm, strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(sshsig.Armor(sig))
+}
+
+// Run git in the directory, failing the test if it fails.
+func Git(t testing.TB, dir, stdin string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// A repository to write commits and tags to, signed or not. Objects are
+// written without updating any reference, as if they had been pushed, unless
+// a reference is updated explicitly.
+type Repo struct {
+	Dir string
+	// The empty tree.
+	Tree string
+	t    testing.TB
+	time int
+}
+
+// Create a repository in a temporary directory, skipping the test if git is
+// not installed.
+func NewRepo(t testing.TB) *Repo {
+	return newRepo(t, "init", "--quiet")
+}
+
+// Create a bare repository, as a server has, in a temporary directory.
+func NewBareRepo(t testing.TB) *Repo {
+	return newRepo(t, "init", "--quiet", "--bare")
+}
+
+func newRepo(t testing.TB, init ...string) *Repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	r := &Repo{Dir: t.TempDir(), t: t, time: 1700000000}
+	Git(t, r.Dir, "", init...)
+	r.Tree = r.Git("", "hash-object", "-t", "tree", "-w", "--stdin")
+	return r
+}
+
+// Run git in the repository.
+func (r *Repo) Git(stdin string, args ...string) string {
+	r.t.Helper()
+	return Git(r.t, r.Dir, stdin, args...)
+}
+
+// Returns the time of the next object, a second after the last.
+func (r *Repo) Time() int {
+	r.time++
+	return r.time
+}
+
+// Write the raw object, returning its name.
+func (r *Repo) WriteObject(objType, raw string) string {
+	r.t.Helper()
+	return r.Git(raw, "hash-object", "-t", objType, "-w", "--stdin")
+}
+
+// Point the reference at the object.
+func (r *Repo) UpdateRef(ref, id string) {
+	r.t.Helper()
+	r.Git("", "update-ref", ref, id)
+}
+
+// Write a commit of the tree on top of the parent, if any, merging the raw
+// tags, and signed by the signer, if any.
+func (r *Repo) Commit(signer ssh.AlgorithmSigner, tree, parent, message string, mergetags ...string) string {
+	r.t.Helper()
+	time := r.Time()
+	headers := "tree " + tree + "\n"
+	if parent != "" {
+		headers += "parent " + parent + "\n"
+	}
+	for _, tag := range mergetags {
+		headers += "mergetag " + indent(tag) + "\n"
+	}
+	headers += fmt.Sprintf("author A U Thor <author@example.com> %d +0000\ncommitter A U Thor <author@example.com> %d +0000\n", time, time)
+	payload := headers + "\n" + message + "\n"
+
+	raw := payload
+	if sig := Sign(r.t, signer, payload); sig != "" {
+		raw = headers + "gpgsig " + indent(sig) + "\n\n" + message + "\n"
+	}
+	return r.WriteObject("commit", raw)
+}
+
+// Write a tree with a file in a directory.
+func (r *Repo) TreeWith(dir, file string) string {
+	r.t.Helper()
+	blob := r.Git(file, "hash-object", "-w", "--stdin")
+	sub := r.Git("100644 blob "+blob+"\t"+file+"\n", "mktree")
+	return r.Git("040000 tree "+sub+"\t"+dir+"\n", "mktree")
+}
+
+// Write an annotated tag of the commit.
+func (r *Repo) Tag(signer ssh.AlgorithmSigner, name, commit string) string {
+	r.t.Helper()
+	return r.WriteObject("tag", r.RawTag(signer, name, commit))
+}
+
+// Returns a raw annotated tag of the commit, without writing it.
+func (r *Repo) RawTag(signer ssh.AlgorithmSigner, name, commit string) string {
+	r.t.Helper()
+	payload := fmt.Sprintf("object %s\ntype commit\ntag %s\ntagger T A Gger <tagger@example.com> %d +0000\n\n%s\n", commit, name, r.Time(), name)
+	return payload + Sign(r.t, signer, payload)
+}
+
+// Indent the continuation lines of a multi-line header value.
+func indent(value string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(value, "\n"), "\n", "\n ")
+}
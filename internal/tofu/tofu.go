@@ -0,0 +1,320 @@
+// Package tofu records the key each committer was first seen signing with,
+// so that signatures by signers missing from the allowed signers file can be
+// trusted on first use, and a later change of key noticed.
+package tofu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	The store has one entry per line, with the following space-separated
+	fields:
+
+		[@changed] principal first-seen keytype base64-key
+
+	The principal is the email of the committer or tagger, and first-seen
+	the time the key was first seen, in RFC 3339 format. An entry marked
+	@changed is a key seen for the principal after the first one, which is
+	not trusted until it is accepted. Empty lines and lines starting with a
+	'#' are ignored.
+*/
+
+const markerChanged = "@changed"
+
+var (
+	ErrKeyChanged    = errors.New("signing key has changed")
+	ErrNoKeyChange   = errors.New("no changed key to accept")
+	ErrAllowedSigner = errors.New("signer is in the allowed signers file with another key")
+)
+
+// A key seen signing for a principal.
+type Entry struct {
+	Principal string
+	Key       ssh.PublicKey
+	FirstSeen time.Time
+	// The line of the entry in the store file, or 0 if it was not loaded
+	// from it.
+	Line int
+	// Set if the key is not the first one seen for the principal, and has
+	// not been accepted.
+	Changed bool
+}
+
+// Returns the SHA256 fingerprint of the key of the entry.
+func (e Entry) Fingerprint() string {
+	return verify.Fingerprint(e.Key)
+}
+
+// The entries of a store file.
+type Store struct {
+	Path    string
+	Entries []Entry
+}
+
+// Returns the default path of the store, next to the configuration of the
+// Keeper Secrets Manager.
+func DefaultPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "keeper", "ssh-sign-tofu"), nil
+}
+
+// Load the store from the given file. A file that does not exist yet is an
+// empty store.
+func Load(path string) (*Store, error) {
+	s := &Store{Path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		e, err := parseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		e.Line = lineNumber
+		s.Entries = append(s.Entries, *e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseEntry(line string) (*Entry, error) {
+	fields := strings.Fields(line)
+	e := &Entry{}
+	if len(fields) > 0 && fields[0] == markerChanged {
+		e.Changed = true
+		fields = fields[1:]
+	}
+	if len(fields) < 4 {
+		return nil, errors.New("missing fields")
+	}
+
+	e.Principal = fields[0]
+	t, err := time.Parse(time.RFC3339, fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid first-seen time '%s'", fields[1])
+	}
+	e.FirstSeen = t
+
+	e.Key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(fields[2] + " " + fields[3]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return e, nil
+}
+
+// How long to wait for another process to release the lock of a store.
+var lockTimeout = 5 * time.Second
+
+// Load the store from the given file, apply the change to it, and write it
+// back if it changed, holding the lock of the file throughout, so that
+// concurrent updates are not lost, e.g. by the checks of signatures git runs
+// for `git log --show-signature` in several worktrees. The store is written
+// to the lock file, which then replaces the store file, as git does, so that
+// a failed write leaves the old one intact.
+func Update(path string, change func(s *Store) error) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := lock(path)
+	if err != nil {
+		return nil, err
+	}
+	// Release the lock, unless it has replaced the store file.
+	renamed := false
+	defer func() {
+		if !renamed {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	s, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	before := s.marshal()
+	if err := change(s); err != nil {
+		return nil, err
+	}
+	after := s.marshal()
+	if after == before {
+		return s, nil
+	}
+
+	if _, err := f.WriteString(after); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+	renamed = true
+	return s, nil
+}
+
+// Create the lock file of the store, <path>.lock, waiting for another
+// process to release it.
+func lock(path string) (*os.File, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked; if no other ssh-sign is running, remove %s.lock", path, path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Returns the entries in the format of the store file.
+func (s *Store) marshal() string {
+	var b strings.Builder
+	for _, e := range s.Entries {
+		if e.Changed {
+			b.WriteString(markerChanged + " ")
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", e.Principal, e.FirstSeen.UTC().Format(time.RFC3339), marshalKey(e.Key))
+	}
+	return b.String()
+}
+
+// Returns the trusted entry of the principal, or nil if no key has been
+// seen for it.
+func (s *Store) Trusted(principal string) *Entry {
+	for i, e := range s.Entries {
+		if !e.Changed && e.Principal == principal {
+			return &s.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Returns the trusted keys of the store as allowed signers for the "git"
+// namespace, to be consulted after the allowed signers file.
+func (s *Store) AllowedSigners() []verify.AllowedSigner {
+	var signers []verify.AllowedSigner
+	for _, e := range s.Entries {
+		if e.Changed {
+			continue
+		}
+		signers = append(signers, verify.AllowedSigner{
+			Email:      e.Principal,
+			Principals: []string{e.Principal},
+			Options:    verify.SignerOptions{Namespaces: []string{sign.DefaultNamespace}},
+			PublicKey:  marshalKey(e.Key),
+			Line:       e.Line,
+		})
+	}
+	return signers
+}
+
+// Record that the principal signed with the key at the given time. The first
+// key seen for a principal is trusted, and true returned. A different key is
+// recorded as changed, and ErrKeyChanged returned. Certificates are not
+// recorded, as they are replaced whenever they expire; their authority
+// belongs in the allowed signers file.
+func (s *Store) See(principal string, key ssh.PublicKey, t time.Time) (bool, error) {
+	if _, ok := key.(*ssh.Certificate); ok {
+		return false, nil
+	}
+
+	trusted := s.Trusted(principal)
+	if trusted == nil {
+		s.Entries = append(s.Entries, Entry{Principal: principal, Key: key, FirstSeen: t})
+		return true, nil
+	}
+	if sameKey(trusted.Key, key) {
+		return false, nil
+	}
+
+	err := fmt.Errorf("%w for %s: first seen with %s key %s on %s, now signed with %s key %s",
+		ErrKeyChanged, principal, verify.KeyType(trusted.Key), trusted.Fingerprint(),
+		trusted.FirstSeen.UTC().Format(time.RFC3339), verify.KeyType(key), verify.Fingerprint(key))
+	for _, e := range s.Entries {
+		if e.Changed && e.Principal == principal && sameKey(e.Key, key) {
+			return false, err
+		}
+	}
+	s.Entries = append(s.Entries, Entry{Principal: principal, Key: key, FirstSeen: t, Changed: true})
+	return false, err
+}
+
+// Accept the changed key of the principal with the given fingerprint,
+// replacing the trusted key and any other changed keys. The fingerprint may
+// be empty if only one key has changed.
+func (s *Store) Accept(principal, fingerprint string) (*Entry, error) {
+	var changed []Entry
+	for _, e := range s.Entries {
+		if e.Changed && e.Principal == principal && (fingerprint == "" || e.Fingerprint() == fingerprint) {
+			changed = append(changed, e)
+		}
+	}
+	if len(changed) == 0 {
+		if fingerprint != "" {
+			return nil, fmt.Errorf("%w for %s with key %s", ErrNoKeyChange, principal, fingerprint)
+		}
+		return nil, fmt.Errorf("%w for %s", ErrNoKeyChange, principal)
+	}
+	if len(changed) > 1 {
+		return nil, fmt.Errorf("%d keys have changed for %s; give the fingerprint of the key to accept", len(changed), principal)
+	}
+
+	accepted := changed[0]
+	accepted.Changed = false
+	entries := s.Entries[:0]
+	for _, e := range s.Entries {
+		if e.Principal != principal {
+			entries = append(entries, e)
+		}
+	}
+	s.Entries = append(entries, accepted)
+	return &accepted, nil
+}
+
+func sameKey(a, b ssh.PublicKey) bool {
+	return a.Type() == b.Type() && string(a.Marshal()) == string(b.Marshal())
+}
+
+// Returns the key in authorized_keys format, i.e. "keytype base64-key".
+func marshalKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
@@ -0,0 +1,315 @@
+package tofu
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/internal/verify"
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
+	"golang.org/x/crypto/ssh"
+)
+
+var firstSeen = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	key := testutil.NewSigner(t).PublicKey()
+	authorized := testutil.AuthorizedKey(key)
+
+	s, err := Load(filepath.Join(dir, "missing"))
+	if err != nil || len(s.Entries) != 0 {
+		t.Fatalf("Load returned %v, %v for a missing file", s, err)
+	}
+
+	path := filepath.Join(dir, "tofu")
+	content := "# comment\n\nalice@example.com 2024-05-01T12:00:00Z " + authorized + "\n" +
+		"@changed alice@example.com 2024-06-01T12:00:00Z " + authorized + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 2 {
+		t.Fatalf("Load returned %d entries, expected 2", len(s.Entries))
+	}
+	e := s.Entries[0]
+	if e.Principal != "alice@example.com" || !e.FirstSeen.Equal(firstSeen) || e.Changed || e.Fingerprint() != ssh.FingerprintSHA256(key) || e.Line != 3 {
+		t.Errorf("Load returned entry %+v", e)
+	}
+	if !s.Entries[1].Changed {
+		t.Errorf("Load returned entry %+v, expected it to be changed", s.Entries[1])
+	}
+
+	for _, line := range []string{
+		"alice@example.com 2024-05-01T12:00:00Z ssh-ed25519",
+		"alice@example.com yesterday " + authorized,
+		"alice@example.com 2024-05-01T12:00:00Z ssh-ed25519 AAAA",
+	} {
+		if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path+":1:") {
+			t.Errorf("Load returned error %v for %q", err, line)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keeper", "ssh-sign-tofu")
+	s, err := Update(path, func(s *Store) error {
+		if _, err := s.See("alice@example.com", testutil.NewSigner(t).PublicKey(), firstSeen); err != nil {
+			return err
+		}
+		if _, err := s.See("alice@example.com", testutil.NewSigner(t).PublicKey(), firstSeen.Add(time.Hour)); !errors.Is(err, ErrKeyChanged) {
+			t.Fatalf("See returned %v, expected a key change", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Update wrote the store with mode %v", info.Mode().Perm())
+	}
+	if _, err := os.Stat(path + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Update left the lock file behind: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Entries) != len(s.Entries) {
+		t.Fatalf("Load returned %d entries, expected %d", len(loaded.Entries), len(s.Entries))
+	}
+	for i, e := range loaded.Entries {
+		want := s.Entries[i]
+		if e.Principal != want.Principal || !e.FirstSeen.Equal(want.FirstSeen) || e.Changed != want.Changed || !sameKey(e.Key, want.Key) {
+			t.Errorf("entry %d is %+v, expected %+v", i, e, want)
+		}
+	}
+
+	// A failed change leaves the store and the lock alone.
+	failed := errors.New("failed")
+	if _, err := Update(path, func(s *Store) error {
+		s.Entries = nil
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Fatalf("Update returned %v, expected %v", err, failed)
+	}
+	if loaded, err := Load(path); err != nil || len(loaded.Entries) != len(s.Entries) {
+		t.Fatalf("Load returned %v, %v after a failed update", loaded, err)
+	}
+	if _, err := os.Stat(path + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Update left the lock file behind: %v", err)
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh-sign-tofu")
+	const n = 20
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		key := testutil.NewSigner(t).PublicKey()
+		principal := fmt.Sprintf("user%d@example.com", i)
+		go func() {
+			_, err := Update(path, func(s *Store) error {
+				_, err := s.See(principal, key, firstSeen)
+				return err
+			})
+			errs <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if s.Trusted(fmt.Sprintf("user%d@example.com", i)) == nil {
+			t.Errorf("the entry of user%d@example.com was lost", i)
+		}
+	}
+}
+
+func TestUpdateLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh-sign-tofu")
+	if err := os.WriteFile(path+".lock", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 50 * time.Millisecond
+
+	_, err := Update(path, func(s *Store) error {
+		t.Error("Update changed a locked store")
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "is locked") {
+		t.Fatalf("Update returned %v, expected the store to be locked", err)
+	}
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Errorf("Update removed the lock of another process: %v", err)
+	}
+}
+
+func TestSee(t *testing.T) {
+	first := testutil.NewSigner(t).PublicKey()
+	second := testutil.NewSigner(t).PublicKey()
+	s := &Store{}
+
+	if recorded, err := s.See("alice@example.com", first, firstSeen); err != nil || !recorded {
+		t.Fatalf("See returned %v, %v for the first key", recorded, err)
+	}
+	if recorded, err := s.See("alice@example.com", first, firstSeen.Add(time.Hour)); err != nil || recorded {
+		t.Fatalf("See returned %v, %v for the same key", recorded, err)
+	}
+	if e := s.Trusted("alice@example.com"); e == nil || !sameKey(e.Key, first) || !e.FirstSeen.Equal(firstSeen) {
+		t.Fatalf("Trusted returned %+v", e)
+	}
+
+	// A different key is recorded once, however often it is seen.
+	for i := 0; i < 2; i++ {
+		_, err := s.See("alice@example.com", second, firstSeen.Add(time.Hour))
+		if !errors.Is(err, ErrKeyChanged) {
+			t.Fatalf("See returned %v, expected a key change", err)
+		}
+		if !strings.Contains(err.Error(), ssh.FingerprintSHA256(first)) || !strings.Contains(err.Error(), ssh.FingerprintSHA256(second)) {
+			t.Errorf("See returned %q, expected both fingerprints", err)
+		}
+	}
+	if len(s.Entries) != 2 || !s.Entries[1].Changed {
+		t.Errorf("See left entries %+v", s.Entries)
+	}
+	if e := s.Trusted("alice@example.com"); !sameKey(e.Key, first) {
+		t.Error("See replaced the trusted key")
+	}
+
+	// The same key may be first seen for another principal.
+	if recorded, err := s.See("bob@example.com", second, firstSeen); err != nil || !recorded {
+		t.Errorf("See returned %v, %v for another principal", recorded, err)
+	}
+}
+
+func TestSeeCertificate(t *testing.T) {
+	ca := testutil.NewSigner(t)
+	cert := &ssh.Certificate{
+		Key:             testutil.NewSigner(t).PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice@example.com"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Store{}
+	if recorded, err := s.See("alice@example.com", cert, firstSeen); err != nil || recorded || len(s.Entries) != 0 {
+		t.Errorf("See returned %v, %v and recorded %+v for a certificate", recorded, err, s.Entries)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	first := testutil.NewSigner(t).PublicKey()
+	second := testutil.NewSigner(t).PublicKey()
+	third := testutil.NewSigner(t).PublicKey()
+	other := testutil.NewSigner(t).PublicKey()
+
+	s := &Store{}
+	s.See("alice@example.com", first, firstSeen)
+	s.See("bob@example.com", other, firstSeen)
+
+	if _, err := s.Accept("alice@example.com", ""); !errors.Is(err, ErrNoKeyChange) {
+		t.Errorf("Accept returned %v without a changed key", err)
+	}
+
+	s.See("alice@example.com", second, firstSeen.Add(time.Hour))
+	s.See("alice@example.com", third, firstSeen.Add(2*time.Hour))
+	if _, err := s.Accept("alice@example.com", ""); err == nil {
+		t.Error("Accept returned no error for several changed keys without a fingerprint")
+	}
+	if _, err := s.Accept("alice@example.com", ssh.FingerprintSHA256(other)); !errors.Is(err, ErrNoKeyChange) {
+		t.Errorf("Accept returned %v for a key that has not changed", err)
+	}
+
+	accepted, err := s.Accept("alice@example.com", ssh.FingerprintSHA256(third))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameKey(accepted.Key, third) || accepted.Changed || !accepted.FirstSeen.Equal(firstSeen.Add(2*time.Hour)) {
+		t.Errorf("Accept returned %+v", accepted)
+	}
+	if len(s.Entries) != 2 {
+		t.Errorf("Accept left entries %+v, expected the other changed key to be removed", s.Entries)
+	}
+	if e := s.Trusted("alice@example.com"); e == nil || !sameKey(e.Key, third) {
+		t.Errorf("Trusted returned %+v after accepting", e)
+	}
+	if e := s.Trusted("bob@example.com"); e == nil || !sameKey(e.Key, other) {
+		t.Errorf("Accept changed the key of another principal: %+v", e)
+	}
+}
+
+func TestAllowedSigners(t *testing.T) {
+	trusted := testutil.NewSigner(t)
+	changed := testutil.NewSigner(t)
+	s := &Store{}
+	s.See("alice@example.com", trusted.PublicKey(), firstSeen)
+	s.See("alice@example.com", changed.PublicKey(), firstSeen)
+
+	signers := s.AllowedSigners()
+	if len(signers) != 1 {
+		t.Fatalf("AllowedSigners returned %d signers, expected only the trusted key", len(signers))
+	}
+	// The entries were not loaded from a file, so have no line.
+	if signers[0].Line != 0 {
+		t.Errorf("AllowedSigners returned line %d", signers[0].Line)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		signer    ssh.AlgorithmSigner
+		namespace string
+		expected  verify.Status
+	}{
+		{name: "trusted", signer: trusted, namespace: "git", expected: verify.StatusGood},
+		{name: "changed", signer: changed, namespace: "git", expected: verify.StatusUnknownSigner},
+		{name: "other namespace", signer: trusted, namespace: "file", expected: verify.StatusNotAllowed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := sshsig.Sign(tt.signer, tt.namespace, sshsig.DefaultHashAlgorithm, strings.NewReader("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := verify.Verify(sshsig.Armor(sig), strings.NewReader("data"), verify.Options{
+				AllowedSigners: signers,
+				Namespace:      tt.namespace,
+				Time:           time.Now(),
+			})
+			if result.Status != tt.expected {
+				t.Errorf("Verify returned %s (%s), expected %s", result.Status, result.Reason, tt.expected)
+			}
+			if result.Status == verify.StatusGood && result.Principal != "alice@example.com" {
+				t.Errorf("Verify returned principal %q", result.Principal)
+			}
+		})
+	}
+}
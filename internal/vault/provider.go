@@ -0,0 +1,18 @@
+package vault
+
+import (
+	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
+	"golang.org/x/crypto/ssh"
+)
+
+// Provides signers for the SSH keys of records in the Vault, given by the
+// UID of the record.
+type Provider struct{}
+
+func (Provider) Signer(uid string) (ssh.AlgorithmSigner, error) {
+	keyPair, err := FetchKeys(uid)
+	if err != nil {
+		return nil, err
+	}
+	return sign.ParsePrivateKey(keyPair.PrivateKey, keyPair.Passphrase)
+}
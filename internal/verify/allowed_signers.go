@@ -0,0 +1,233 @@
+package verify
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	The allowed signers format is documented in the ALLOWED SIGNERS section
+	of ssh-keygen(1): https://man.openbsd.org/ssh-keygen.1#ALLOWED_SIGNERS
+
+	Each line contains the following space-separated fields:
+
+		principals [options] keytype base64-key [comment]
+
+	Empty lines and lines starting with a '#' are ignored.
+*/
+
+type AllowedSigner struct {
+	// The principals field as it appears in the file, without quotes.
+	Email string
+	// The comma-separated principals of the Email field.
+	Principals []string
+	Options    SignerOptions
+	// The public key in authorized_keys format, i.e. "keytype base64-key".
+	PublicKey string
+	// The line of the allowed signers file the entry was read from.
+	Line int
+}
+
+// The options that may be set on a line of the allowed signers file.
+type SignerOptions struct {
+	CertAuthority   bool
+	Namespaces      []string
+	ValidAfter      time.Time
+	ValidBefore     time.Time
+	NoTouchRequired bool
+	VerifyRequired  bool
+}
+
+var (
+	ErrKeyNotYetValid = errors.New("key is not yet valid")
+	ErrKeyExpired     = errors.New("key has expired")
+)
+
+// The layout ssh-keygen uses when reporting times.
+const timeLayout = "2006-01-02T15:04:05"
+
+// Parse a given file and returns a slice of AllowedSigners.
+func GetAllowedSigners(f string) ([]AllowedSigner, error) {
+	asf, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer asf.Close()
+
+	return parseAllowedSigners(asf, f)
+}
+
+// Parse the allowed signers read from r. The name is used to identify the
+// source of any errors.
+func parseAllowedSigners(r io.Reader, name string) ([]AllowedSigner, error) {
+	var allowedSigners []AllowedSigner
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		as, err := parseAllowedSignerLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, lineNumber, err)
+		}
+		as.Line = lineNumber
+		allowedSigners = append(allowedSigners, *as)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return allowedSigners, nil
+}
+
+// Parse a single, non-empty line of an allowed signers file.
+func parseAllowedSignerLine(line string) (*AllowedSigner, error) {
+	var principals, rest string
+	if strings.HasPrefix(line, "\"") {
+		end := strings.Index(line[1:], "\"")
+		if end == -1 {
+			return nil, fmt.Errorf("missing closing quote in principals")
+		}
+		principals = line[1 : end+1]
+		rest = line[end+2:]
+	} else {
+		end := strings.IndexAny(line, " \t")
+		if end == -1 {
+			return nil, fmt.Errorf("missing public key")
+		}
+		principals = line[:end]
+		rest = line[end:]
+	}
+
+	if principals == "" {
+		return nil, fmt.Errorf("missing principals")
+	}
+	if rest == "" || !strings.ContainsAny(rest[:1], " \t") {
+		return nil, fmt.Errorf("missing whitespace after principals")
+	}
+
+	// The remainder of the line has the same layout as an authorized_keys
+	// line, so ParseAuthorizedKey handles the optional, possibly quoted,
+	// options field for us.
+	key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(rest))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	opts, err := parseSignerOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AllowedSigner{
+		Email:      principals,
+		Principals: strings.Split(principals, ","),
+		Options:    *opts,
+		PublicKey:  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	}, nil
+}
+
+// Parse the options field of an allowed signers line. Unknown options are
+// rejected, as they may restrict the use of the key in ways that we would
+// otherwise silently ignore.
+func parseSignerOptions(options []string) (*SignerOptions, error) {
+	opts := SignerOptions{}
+	for _, o := range options {
+		name, value, hasValue := strings.Cut(o, "=")
+		value = strings.Trim(value, "\"")
+
+		switch strings.ToLower(name) {
+		case "cert-authority":
+			if hasValue {
+				return nil, fmt.Errorf("option '%s' does not take a value", name)
+			}
+			opts.CertAuthority = true
+		case "no-touch-required":
+			if hasValue {
+				return nil, fmt.Errorf("option '%s' does not take a value", name)
+			}
+			opts.NoTouchRequired = true
+		case "verify-required":
+			if hasValue {
+				return nil, fmt.Errorf("option '%s' does not take a value", name)
+			}
+			opts.VerifyRequired = true
+		case "namespaces":
+			if value == "" {
+				return nil, fmt.Errorf("option '%s' requires a value", name)
+			}
+			opts.Namespaces = strings.Split(value, ",")
+		case "valid-after":
+			t, err := ParseTime(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'valid-after' time: %w", err)
+			}
+			opts.ValidAfter = t
+		case "valid-before":
+			t, err := ParseTime(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'valid-before' time: %w", err)
+			}
+			opts.ValidBefore = t
+		default:
+			return nil, fmt.Errorf("unsupported option '%s'", name)
+		}
+	}
+
+	return &opts, nil
+}
+
+// Checks that the key of the allowed signer may be used at the given time,
+// according to its valid-after and valid-before options.
+func (as AllowedSigner) ValidAt(t time.Time) error {
+	if !as.Options.ValidAfter.IsZero() && t.Before(as.Options.ValidAfter) {
+		return fmt.Errorf("%w: verify time %s < valid-after %s", ErrKeyNotYetValid,
+			t.Local().Format(timeLayout), as.Options.ValidAfter.Local().Format(timeLayout))
+	}
+	if !as.Options.ValidBefore.IsZero() && t.After(as.Options.ValidBefore) {
+		return fmt.Errorf("%w: verify time %s > valid-before %s", ErrKeyExpired,
+			t.Local().Format(timeLayout), as.Options.ValidBefore.Local().Format(timeLayout))
+	}
+	return nil
+}
+
+// Parse a time in one of the formats accepted by ssh-keygen: YYYYMMDD,
+// YYYYMMDDHHMM or YYYYMMDDHHMMSS. Times are interpreted in the local time
+// zone unless suffixed with 'Z', in which case they are interpreted as UTC.
+func ParseTime(s string) (time.Time, error) {
+	loc := time.Local
+	value := s
+	if strings.HasSuffix(value, "Z") || strings.HasSuffix(value, "z") {
+		loc = time.UTC
+		value = value[:len(value)-1]
+	}
+
+	var layout string
+	switch len(value) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("unsupported time format '%s'", s)
+	}
+
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unsupported time format '%s'", s)
+	}
+	return t, nil
+}
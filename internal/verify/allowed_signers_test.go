@@ -0,0 +1,192 @@
+package verify
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAllowedSigners(t *testing.T) {
+	file := strings.Join([]string{
+		"# Team signing keys",
+		"",
+		"   ",
+		"test@example.com " + ed25519PublicKey + " laptop key",
+		"alice@example.com,bob@example.com " + rsaPublicKey,
+		`"carol@example.com,*@example.org" ` + ed25519PublicKey,
+		`*@example.com cert-authority,namespaces="git,file" ` + ed25519PublicKey + " CA",
+		`dave@example.com valid-after="20240101",valid-before="20250101120000Z" ` + ed25519PublicKey,
+	}, "\n")
+
+	as, err := parseAllowedSigners(strings.NewReader(file), "allowed_signers")
+	if err != nil {
+		t.Fatalf("parseAllowedSigners returned an error: %v", err)
+	}
+
+	expected := []AllowedSigner{
+		{
+			Email:      "test@example.com",
+			Principals: []string{"test@example.com"},
+			PublicKey:  ed25519PublicKey,
+			Line:       4,
+		},
+		{
+			Email:      "alice@example.com,bob@example.com",
+			Principals: []string{"alice@example.com", "bob@example.com"},
+			PublicKey:  rsaPublicKey,
+			Line:       5,
+		},
+		{
+			Email:      "carol@example.com,*@example.org",
+			Principals: []string{"carol@example.com", "*@example.org"},
+			PublicKey:  ed25519PublicKey,
+			Line:       6,
+		},
+		{
+			Email:      "*@example.com",
+			Principals: []string{"*@example.com"},
+			Options: SignerOptions{
+				CertAuthority: true,
+				Namespaces:    []string{"git", "file"},
+			},
+			PublicKey: ed25519PublicKey,
+			Line:      7,
+		},
+		{
+			Email:      "dave@example.com",
+			Principals: []string{"dave@example.com"},
+			Options: SignerOptions{
+				ValidAfter:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+				ValidBefore: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+			},
+			PublicKey: ed25519PublicKey,
+			Line:      8,
+		},
+	}
+
+	if !reflect.DeepEqual(as, expected) {
+		t.Errorf("parseAllowedSigners returned %v, expected %v", as, expected)
+	}
+}
+
+func TestParseAllowedSignersErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		line string
+	}{
+		{
+			name: "missing key",
+			line: "test@example.com",
+		},
+		{
+			name: "invalid key",
+			line: "test@example.com ssh-ed25519 invalid",
+		},
+		{
+			name: "unterminated quote",
+			line: `"test@example.com ` + ed25519PublicKey,
+		},
+		{
+			name: "unknown option",
+			line: "test@example.com no-such-option " + ed25519PublicKey,
+		},
+		{
+			name: "invalid time",
+			line: "test@example.com valid-after=2024 " + ed25519PublicKey,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			file := "# comment\n" + tt.line + "\n"
+			_, err := parseAllowedSigners(strings.NewReader(file), "allowed_signers")
+			if err == nil {
+				t.Fatal("parseAllowedSigners returned no error, expected error")
+			}
+			if !strings.HasPrefix(err.Error(), "allowed_signers:2: ") {
+				t.Errorf("error does not name the line number: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for _, tt := range []struct {
+		value    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{
+			value:    "20240102",
+			expected: time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local),
+		},
+		{
+			value:    "202401021304",
+			expected: time.Date(2024, 1, 2, 13, 4, 0, 0, time.Local),
+		},
+		{
+			value:    "20240102130405Z",
+			expected: time.Date(2024, 1, 2, 13, 4, 5, 0, time.UTC),
+		},
+		{
+			value:   "2024-01-02",
+			wantErr: true,
+		},
+		{
+			value:   "20241302",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTime(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTime expected error: %v, got: %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !got.Equal(tt.expected) {
+				t.Errorf("ParseTime returned %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidAt(t *testing.T) {
+	as := AllowedSigner{
+		Options: SignerOptions{
+			ValidAfter:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			ValidBefore: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		time     time.Time
+		expected error
+	}{
+		{
+			name: "within window",
+			time: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "before valid-after",
+			time:     time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC),
+			expected: ErrKeyNotYetValid,
+		},
+		{
+			name:     "after valid-before",
+			time:     time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC),
+			expected: ErrKeyExpired,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := as.ValidAt(tt.time)
+			if !errors.Is(err, tt.expected) || (err != nil) != (tt.expected != nil) {
+				t.Errorf("ValidAt returned %v, expected %v", err, tt.expected)
+			}
+		})
+	}
+
+	// Without options, a key is always valid
+	if err := (AllowedSigner{}).ValidAt(time.Now()); err != nil {
+		t.Errorf("ValidAt returned an error: %v", err)
+	}
+}
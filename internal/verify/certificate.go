@@ -0,0 +1,73 @@
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrCertNotYetValid = errors.New("certificate is not yet valid")
+	ErrCertExpired     = errors.New("certificate has expired")
+)
+
+// Checks that the certificate was issued by the given certificate authority
+// as a user certificate and that it is valid at the given time. The CA
+// signature over the certificate is verified, as ssh.ParsePublicKey does not
+// do so when the certificate is unpacked from the signature.
+func checkCertificate(cert *ssh.Certificate, ca ssh.PublicKey, t time.Time) error {
+	if cert.CertType != ssh.UserCert {
+		return errors.New("certificate is not a user certificate")
+	}
+
+	if !bytes.Equal(cert.SignatureKey.Marshal(), ca.Marshal()) {
+		return errors.New("certificate was not issued by the certificate authority")
+	}
+
+	// Timestamps are unsigned; a valid-after beyond the range of int64 can
+	// never be reached.
+	if cert.ValidAfter > uint64(1<<63-1) || t.Unix() < int64(cert.ValidAfter) {
+		return fmt.Errorf("%w: verify time %s < valid-after %s", ErrCertNotYetValid,
+			t.Local().Format(timeLayout), formatCertTime(cert.ValidAfter))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && cert.ValidBefore <= uint64(1<<63-1) &&
+		t.Unix() >= int64(cert.ValidBefore) {
+		return fmt.Errorf("%w: verify time %s >= valid-before %s", ErrCertExpired,
+			t.Local().Format(timeLayout), formatCertTime(cert.ValidBefore))
+	}
+
+	// The CA signs the certificate with every field except the signature
+	// itself, i.e. the marshalled certificate without its trailing, empty,
+	// signature string.
+	unsigned := *cert
+	unsigned.Signature = nil
+	b := unsigned.Marshal()
+	if err := cert.SignatureKey.Verify(b[:len(b)-4], cert.Signature); err != nil {
+		return fmt.Errorf("invalid certificate signature: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the principals of the certificate that match the principal
+// patterns from an allowed signers line.
+func certPrincipals(cert *ssh.Certificate, patterns []string) []string {
+	var principals []string
+	for _, p := range cert.ValidPrincipals {
+		if MatchPatternList(p, patterns) {
+			principals = append(principals, p)
+		}
+	}
+	return principals
+}
+
+// Format a certificate timestamp for use in error messages.
+func formatCertTime(ts uint64) string {
+	if ts > uint64(1<<63-1) {
+		return "forever"
+	}
+	return time.Unix(int64(ts), 0).Local().Format(timeLayout)
+}
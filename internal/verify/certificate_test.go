@@ -0,0 +1,181 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
+	"golang.org/x/crypto/ssh"
+)
+
+// Issue a user certificate for the key of the signer, signed by the CA.
+func newTestCertificate(t *testing.T, ca ssh.Signer, key ssh.PublicKey, principals []string, validAfter, validBefore time.Time) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          1,
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestFindSignersCertificate(t *testing.T) {
+	ca := testutil.NewSigner(t)
+	otherCA := testutil.NewSigner(t)
+	user := testutil.NewSigner(t)
+
+	validAfter := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	validBefore := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	verifyTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	cert := newTestCertificate(t, ca, user.PublicKey(), []string{"alice@example.com", "alice@example.org"}, validAfter, validBefore)
+
+	caSigner := AllowedSigner{
+		Email:      "*@example.com",
+		Principals: []string{"*@example.com"},
+		Options:    SignerOptions{CertAuthority: true},
+		PublicKey:  testutil.AuthorizedKey(ca.PublicKey()),
+		Line:       1,
+	}
+
+	// The certificate is issued by the CA for a principal it accepts
+	matches, err := FindSigners([]AllowedSigner{caSigner}, cert, verifyTime)
+	if err != nil {
+		t.Fatalf("FindSigners returned an error: %v", err)
+	}
+	if len(matches) != 1 || strings.Join(matches[0].Principals, ",") != "alice@example.com" {
+		t.Errorf("FindSigners returned %v, expected alice@example.com", matches)
+	}
+
+	// The certificate must list the principal it is verified for
+	if _, err := FindSigner([]AllowedSigner{caSigner}, "alice@example.com", &Signature{PublicKey: cert}, "git", verifyTime); err != nil {
+		t.Errorf("FindSigner returned an error: %v", err)
+	}
+	if _, err := FindSigner([]AllowedSigner{caSigner}, "bob@example.com", &Signature{PublicKey: cert}, "git", verifyTime); !errors.Is(err, ErrNoPrincipalMatched) {
+		t.Errorf("FindSigner returned %v, expected %v", err, ErrNoPrincipalMatched)
+	}
+
+	// The certificate has expired
+	_, err = FindSigners([]AllowedSigner{caSigner}, cert, validBefore)
+	if !errors.Is(err, ErrCertExpired) {
+		t.Errorf("FindSigners returned %v, expected %v", err, ErrCertExpired)
+	}
+
+	// The certificate is not yet valid
+	_, err = FindSigners([]AllowedSigner{caSigner}, cert, validAfter.Add(-time.Second))
+	if !errors.Is(err, ErrCertNotYetValid) {
+		t.Errorf("FindSigners returned %v, expected %v", err, ErrCertNotYetValid)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		signer AllowedSigner
+	}{
+		{
+			name: "other CA",
+			signer: AllowedSigner{
+				Principals: []string{"*@example.com"},
+				Options:    SignerOptions{CertAuthority: true},
+				PublicKey:  testutil.AuthorizedKey(otherCA.PublicKey()),
+			},
+		},
+		{
+			name: "CA without cert-authority",
+			signer: AllowedSigner{
+				Principals: []string{"*@example.com"},
+				PublicKey:  testutil.AuthorizedKey(ca.PublicKey()),
+			},
+		},
+		{
+			name: "plain key of the certificate",
+			signer: AllowedSigner{
+				Principals: []string{"alice@example.com"},
+				PublicKey:  testutil.AuthorizedKey(user.PublicKey()),
+			},
+		},
+		{
+			name: "no matching principal",
+			signer: AllowedSigner{
+				Principals: []string{"bob@example.com"},
+				Options:    SignerOptions{CertAuthority: true},
+				PublicKey:  testutil.AuthorizedKey(ca.PublicKey()),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := FindSigners([]AllowedSigner{tt.signer}, cert, verifyTime)
+			if err != nil || len(matches) != 0 {
+				t.Errorf("FindSigners returned %v, %v, expected no matches", matches, err)
+			}
+		})
+	}
+}
+
+func TestCheckCertificateSignature(t *testing.T) {
+	ca := testutil.NewSigner(t)
+	user := testutil.NewSigner(t)
+	now := time.Now()
+	cert := newTestCertificate(t, ca, user.PublicKey(), []string{"alice@example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	if err := checkCertificate(cert, ca.PublicKey(), now); err != nil {
+		t.Errorf("checkCertificate returned an error: %v", err)
+	}
+
+	// Tampering with the certificate invalidates the CA signature
+	cert.ValidPrincipals = []string{"mallory@example.com"}
+	if err := checkCertificate(cert, ca.PublicKey(), now); err == nil {
+		t.Error("checkCertificate returned no error for a tampered certificate")
+	}
+}
+
+func TestVerifyMessageCertificate(t *testing.T) {
+	data := []byte("Hello, certificate!")
+	ca := testutil.NewSigner(t)
+	user := testutil.NewSigner(t)
+	now := time.Now()
+	cert := newTestCertificate(t, ca, user.PublicKey(), []string{"alice@example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	certSigner, err := ssh.NewCertSigner(cert, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	as, ok := certSigner.(ssh.AlgorithmSigner)
+	if !ok {
+		t.Fatal("certificate signer is not an AlgorithmSigner")
+	}
+
+	signature, err := sshsig.Sign(as, sign.DefaultNamespace, sshsig.DefaultHashAlgorithm, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := Decode(sshsig.Armor(signature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sig.PublicKey.(*ssh.Certificate); !ok {
+		t.Fatalf("Decode returned a %T, expected a certificate", sig.PublicKey)
+	}
+	if KeyType(sig.PublicKey) != "ED25519-CERT" {
+		t.Errorf("KeyType returned %s, expected ED25519-CERT", KeyType(sig.PublicKey))
+	}
+	if Fingerprint(sig.PublicKey) != ssh.FingerprintSHA256(user.PublicKey()) {
+		t.Errorf("Fingerprint of the certificate does not match the certified key")
+	}
+
+	if err := VerifyMessage(sig, bytes.NewReader(data), sign.DefaultNamespace); err != nil {
+		t.Errorf("VerifyMessage returned an error: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package verify
+
+import "strings"
+
+// Finds the allowed signers whose principals match the given principal, using
+// OpenSSH pattern-list semantics. The principals field of each matching
+// allowed signer is returned.
+func MatchPrincipals(signers []AllowedSigner, principal string) []string {
+	var matches []string
+	for _, as := range signers {
+		if MatchPatternList(principal, as.Principals) {
+			matches = append(matches, as.Email)
+		}
+	}
+	return matches
+}
+
+// Match a string against a list of OpenSSH style wildcard patterns, e.g. a
+// principal against the principals of an allowed signer. A pattern
+// prefixed with '!' is negated; if the string matches a negated pattern, the
+// list does not match, regardless of any other pattern in it.
+func MatchPatternList(s string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated := strings.HasPrefix(pattern, "!"); negated {
+			if matchPattern(s, pattern[1:]) {
+				return false
+			}
+		} else if matchPattern(s, pattern) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// Match a string against an OpenSSH style wildcard pattern, where '*' matches
+// any sequence of characters and '?' matches exactly one character.
+func matchPattern(s, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive wildcards, then try to match the rest of
+			// the pattern at every remaining position.
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(s[i:], pattern) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		s = s[1:]
+		pattern = pattern[1:]
+	}
+	return s == ""
+}
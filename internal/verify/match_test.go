@@ -0,0 +1,70 @@
+package verify
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	for _, tt := range []struct {
+		s        string
+		pattern  string
+		expected bool
+	}{
+		{"alice@example.com", "alice@example.com", true},
+		{"alice@example.com", "bob@example.com", false},
+		{"alice@example.com", "*@example.com", true},
+		{"alice@example.com", "*@example.org", false},
+		{"alice@example.com", "?lice@example.com", true},
+		{"alice@example.com", "??lice@example.com", false},
+		{"alice@example.com", "a**e@*", true},
+		{"alice@example.com", "*", true},
+		{"", "*", true},
+		{"", "?", false},
+	} {
+		if got := matchPattern(tt.s, tt.pattern); got != tt.expected {
+			t.Errorf("matchPattern(%q, %q) returned %v, expected %v", tt.s, tt.pattern, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchPatternList(t *testing.T) {
+	for _, tt := range []struct {
+		s        string
+		patterns []string
+		expected bool
+	}{
+		{"alice@example.com", []string{"bob@example.com", "alice@example.com"}, true},
+		{"alice@example.com", []string{"*@example.com", "!alice@example.com"}, false},
+		{"alice@example.com", []string{"!alice@example.com", "*@example.com"}, false},
+		{"bob@example.com", []string{"*@example.com", "!alice@example.com"}, true},
+		{"alice@example.com", []string{"!bob@example.com"}, false},
+		{"alice@example.com", []string{}, false},
+	} {
+		if got := MatchPatternList(tt.s, tt.patterns); got != tt.expected {
+			t.Errorf("MatchPatternList(%q, %q) returned %v, expected %v", tt.s, tt.patterns, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchPrincipals(t *testing.T) {
+	signers := []AllowedSigner{
+		{Email: "alice@example.com", Principals: []string{"alice@example.com"}},
+		{Email: "*@example.com,!bob@example.com", Principals: []string{"*@example.com", "!bob@example.com"}},
+		{Email: "bob@example.com,carol@example.com", Principals: []string{"bob@example.com", "carol@example.com"}},
+	}
+
+	for _, tt := range []struct {
+		principal string
+		expected  []string
+	}{
+		{"alice@example.com", []string{"alice@example.com", "*@example.com,!bob@example.com"}},
+		{"bob@example.com", []string{"bob@example.com,carol@example.com"}},
+		{"dave@example.org", nil},
+	} {
+		got := MatchPrincipals(signers, tt.principal)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("MatchPrincipals(%q) returned %v, expected %v", tt.principal, got, tt.expected)
+		}
+	}
+}
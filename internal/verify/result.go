@@ -0,0 +1,216 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type Status string
+
+const (
+	// The signature is valid and, unless the signer was not checked, made by
+	// an allowed signer.
+	StatusGood Status = "good"
+	// The signature does not match the signed data or namespace.
+	StatusBadSignature Status = "bad-signature"
+	// The key is not authorized by any allowed signer for the principal.
+	StatusUnknownSigner Status = "unknown-signer"
+	// The key or certificate was not valid at the verify time.
+	StatusExpired Status = "expired"
+	// The key, certificate or certificate authority has been revoked.
+	StatusRevoked Status = "revoked"
+	// The key is authorized, but not for the namespace or a permitted
+	// principal, or the security key signature lacks a required flag.
+	StatusNotAllowed Status = "not-allowed"
+	// The signature could not be parsed.
+	StatusError Status = "error"
+	// The git object that was verified has no signature.
+	StatusUnsigned Status = "unsigned"
+	// The git object that was verified has an OpenPGP or X.509 signature.
+	StatusNonSSH Status = "non-ssh"
+	// The signature is good, but the signer is not authorized by the signing
+	// policy for the reference or the paths that were changed.
+	StatusUnauthorized Status = "unauthorized"
+)
+
+// The outcome of verifying a signature. Fields that do not apply, or could
+// not be determined before verification failed, are left empty.
+type Result struct {
+	Status        Status     `json:"status"`
+	Principal     string     `json:"principal,omitempty"`
+	KeyType       string     `json:"key_type,omitempty"`
+	Fingerprint   string     `json:"fingerprint,omitempty"`
+	Namespace     string     `json:"namespace,omitempty"`
+	HashAlgorithm string     `json:"hash_algorithm,omitempty"`
+	Reason        string     `json:"reason,omitempty"`
+	ValidAfter    *time.Time `json:"valid_after,omitempty"`
+	ValidBefore   *time.Time `json:"valid_before,omitempty"`
+	// The error verification failed with, for use with errors.Is.
+	Err error `json:"-"`
+	// The allowed signer that authorized the key of a good signature.
+	Signer *AllowedSigner `json:"-"`
+}
+
+type Options struct {
+	AllowedSigners []AllowedSigner
+	// The principal to verify the signer as. If empty, the first principal
+	// of the first allowed signer that authorizes the key is used, as git
+	// does with the output of find-principals.
+	Principal string
+	// If set, and no principal is given, the signer must be authorized for
+	// a principal matching this pattern-list, e.g. "*@release.example.com".
+	PermittedPrincipals []string
+	Namespace           string
+	// The time to check the validity of keys and certificates at.
+	Time time.Time
+	// If set, signatures made with revoked keys are rejected.
+	Revocations *RevocationList
+	// Only check the signature against the signed data, without checking
+	// the signer against the allowed signers, as in check-novalidate.
+	NoValidate bool
+}
+
+// Verify an armored signature of the data. The signature is checked against
+// the data and namespace, the key against the revocation list, and the
+// signer against the allowed signers, in that order. The first failure
+// determines the status of the result.
+func Verify(armored []byte, data io.Reader, opts Options) *Result {
+	result := &Result{Namespace: opts.Namespace}
+
+	sig, err := Decode(armored)
+	if err != nil {
+		return result.fail(StatusError, err)
+	}
+	result.KeyType = KeyType(sig.PublicKey)
+	result.Fingerprint = Fingerprint(sig.PublicKey)
+	result.HashAlgorithm = sig.HashAlgorithm
+
+	if err := VerifyMessage(sig, data, opts.Namespace); err != nil {
+		return result.fail(StatusBadSignature, err)
+	}
+
+	if opts.Revocations != nil {
+		if err := opts.Revocations.CheckKey(sig.PublicKey); err != nil {
+			return result.fail(StatusRevoked, err)
+		}
+	}
+
+	if opts.NoValidate {
+		result.Status = StatusGood
+		return result
+	}
+
+	principal := opts.Principal
+	if principal == "" {
+		matches, err := FindSigners(opts.AllowedSigners, sig.PublicKey, opts.Time)
+		if err != nil {
+			return result.fail(statusFor(err), err)
+		}
+		if len(matches) == 0 {
+			return result.fail(StatusUnknownSigner, ErrNoPrincipalMatched)
+		}
+		principal = permittedPrincipal(matches, opts.PermittedPrincipals)
+		if principal == "" {
+			return result.fail(StatusNotAllowed, fmt.Errorf("%w: %s", ErrPrincipalNotPermitted, strings.Join(matches[0].Principals, ",")))
+		}
+	}
+
+	signer, err := FindSigner(opts.AllowedSigners, principal, sig, opts.Namespace, opts.Time)
+	if err != nil {
+		return result.fail(statusFor(err), err)
+	}
+
+	result.Status = StatusGood
+	result.Principal = principal
+	result.Signer = signer
+	result.ValidAfter, result.ValidBefore = validityWindow(signer, sig.PublicKey)
+	return result
+}
+
+// Returns the first principal of the matches that is permitted by the
+// pattern-list, or an empty string if none is. If there are no patterns,
+// every principal is permitted.
+func permittedPrincipal(matches []Match, patterns []string) string {
+	for _, m := range matches {
+		for _, p := range m.Principals {
+			if len(patterns) == 0 || MatchPatternList(p, patterns) {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// The lines ssh-keygen would print for the result. Failures are described by
+// their reason.
+func (r *Result) Text() string {
+	switch r.Status {
+	case StatusGood:
+		if r.Principal == "" {
+			return fmt.Sprintf("Good \"%s\" signature with %s key %s\nNo matching principal", r.Namespace, r.KeyType, r.Fingerprint)
+		}
+		return fmt.Sprintf("Good \"%s\" signature for %s with %s key %s", r.Namespace, r.Principal, r.KeyType, r.Fingerprint)
+	case StatusBadSignature:
+		return fmt.Sprintf("Signature verification failed: %s", r.Reason)
+	case StatusRevoked:
+		return fmt.Sprintf("Signature %s", r.Reason)
+	default:
+		return r.Reason
+	}
+}
+
+func (r *Result) fail(status Status, err error) *Result {
+	r.Status = status
+	r.Reason = err.Error()
+	r.Err = err
+	return r
+}
+
+// Classify the error returned when looking up the signer of a signature.
+func statusFor(err error) Status {
+	switch {
+	case errors.Is(err, ErrKeyExpired), errors.Is(err, ErrKeyNotYetValid),
+		errors.Is(err, ErrCertExpired), errors.Is(err, ErrCertNotYetValid):
+		return StatusExpired
+	case errors.Is(err, ErrNamespaceNotPermitted), errors.Is(err, ErrPrincipalNotPermitted),
+		errors.Is(err, ErrUserPresenceRequired), errors.Is(err, ErrUserVerificationRequired):
+		return StatusNotAllowed
+	case errors.Is(err, ErrKeyRevoked):
+		return StatusRevoked
+	default:
+		return StatusUnknownSigner
+	}
+}
+
+// The window in which the key may be used, i.e. the intersection of the
+// validity of the allowed signer and, for a certificate, of the certificate.
+func validityWindow(as *AllowedSigner, key ssh.PublicKey) (*time.Time, *time.Time) {
+	after := as.Options.ValidAfter
+	before := as.Options.ValidBefore
+	if cert, ok := key.(*ssh.Certificate); ok {
+		if cert.ValidAfter != 0 && cert.ValidAfter <= uint64(1<<63-1) {
+			if t := time.Unix(int64(cert.ValidAfter), 0); t.After(after) {
+				after = t
+			}
+		}
+		if cert.ValidBefore != ssh.CertTimeInfinity && cert.ValidBefore <= uint64(1<<63-1) {
+			if t := time.Unix(int64(cert.ValidBefore), 0); before.IsZero() || t.Before(before) {
+				before = t
+			}
+		}
+	}
+
+	var validAfter, validBefore *time.Time
+	if !after.IsZero() {
+		validAfter = &after
+	}
+	if !before.IsZero() {
+		validBefore = &before
+	}
+	return validAfter, validBefore
+}
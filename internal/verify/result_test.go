@@ -0,0 +1,299 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVerify(t *testing.T) {
+	signer := testutil.NewSigner(t)
+	other := testutil.NewSigner(t)
+	data := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904")
+
+	signature, err := sshsig.Sign(signer.(ssh.AlgorithmSigner), "git", sshsig.DefaultHashAlgorithm, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	armored := sshsig.Armor(signature)
+
+	verifyTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	validBefore := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	signers := []AllowedSigner{
+		{
+			Email:      "other@example.com",
+			Principals: []string{"other@example.com"},
+			PublicKey:  testutil.AuthorizedKey(other.PublicKey()),
+			Line:       1,
+		},
+		{
+			Email:      "test@example.com",
+			Principals: []string{"test@example.com"},
+			PublicKey:  testutil.AuthorizedKey(signer.PublicKey()),
+			Options:    SignerOptions{ValidBefore: validBefore},
+			Line:       2,
+		},
+	}
+
+	revoked := newRevocationList()
+	revoked.keys[string(signer.PublicKey().Marshal())] = true
+
+	tests := []struct {
+		name      string
+		armored   []byte
+		data      []byte
+		opts      Options
+		status    Status
+		principal string
+		err       error
+	}{
+		{
+			name:      "good",
+			opts:      Options{AllowedSigners: signers, Principal: "test@example.com", Namespace: "git", Time: verifyTime},
+			status:    StatusGood,
+			principal: "test@example.com",
+		},
+		{
+			name:      "good without principal",
+			opts:      Options{AllowedSigners: signers, Namespace: "git", Time: verifyTime},
+			status:    StatusGood,
+			principal: "test@example.com",
+		},
+		{
+			name:      "permitted principal",
+			opts:      Options{AllowedSigners: signers, Namespace: "git", Time: verifyTime, PermittedPrincipals: []string{"release@example.com", "test@*"}},
+			status:    StatusGood,
+			principal: "test@example.com",
+		},
+		{
+			name:   "principal not permitted",
+			opts:   Options{AllowedSigners: signers, Namespace: "git", Time: verifyTime, PermittedPrincipals: []string{"*@example.com", "!test@*"}},
+			status: StatusNotAllowed,
+			err:    ErrPrincipalNotPermitted,
+		},
+		{
+			name:   "good without validation",
+			opts:   Options{Namespace: "git", Time: verifyTime, NoValidate: true},
+			status: StatusGood,
+		},
+		{
+			name:   "bad signature",
+			data:   []byte("tampered"),
+			opts:   Options{AllowedSigners: signers, Principal: "test@example.com", Namespace: "git", Time: verifyTime},
+			status: StatusBadSignature,
+		},
+		{
+			name:   "bad namespace",
+			opts:   Options{AllowedSigners: signers, Principal: "test@example.com", Namespace: "file", Time: verifyTime},
+			status: StatusBadSignature,
+		},
+		{
+			name:   "unknown signer",
+			opts:   Options{AllowedSigners: signers, Principal: "other@example.com", Namespace: "git", Time: verifyTime},
+			status: StatusUnknownSigner,
+			err:    ErrNoPrincipalMatched,
+		},
+		{
+			name:   "no allowed signers",
+			opts:   Options{Namespace: "git", Time: verifyTime},
+			status: StatusUnknownSigner,
+			err:    ErrNoPrincipalMatched,
+		},
+		{
+			name:   "expired",
+			opts:   Options{AllowedSigners: signers, Principal: "test@example.com", Namespace: "git", Time: validBefore.Add(time.Hour)},
+			status: StatusExpired,
+			err:    ErrKeyExpired,
+		},
+		{
+			name:   "revoked",
+			opts:   Options{AllowedSigners: signers, Principal: "test@example.com", Namespace: "git", Time: verifyTime, Revocations: revoked},
+			status: StatusRevoked,
+			err:    ErrKeyRevoked,
+		},
+		{
+			name: "namespace not permitted",
+			opts: Options{
+				AllowedSigners: []AllowedSigner{{
+					Email:      "test@example.com",
+					Principals: []string{"test@example.com"},
+					PublicKey:  testutil.AuthorizedKey(signer.PublicKey()),
+					Options:    SignerOptions{Namespaces: []string{"file"}},
+					Line:       1,
+				}},
+				Principal: "test@example.com",
+				Namespace: "git",
+				Time:      verifyTime,
+			},
+			status: StatusNotAllowed,
+			err:    ErrNamespaceNotPermitted,
+		},
+		{
+			name:    "parse error",
+			armored: []byte("not a signature"),
+			opts:    Options{AllowedSigners: signers, Principal: "test@example.com", Namespace: "git", Time: verifyTime},
+			status:  StatusError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := armored
+			if tt.armored != nil {
+				a = tt.armored
+			}
+			d := data
+			if tt.data != nil {
+				d = tt.data
+			}
+
+			result := Verify(a, bytes.NewReader(d), tt.opts)
+			if result.Status != tt.status {
+				t.Fatalf("Verify returned status %s, expected %s: %s", result.Status, tt.status, result.Reason)
+			}
+			if result.Principal != tt.principal {
+				t.Errorf("Verify returned principal %q, expected %q", result.Principal, tt.principal)
+			}
+			if tt.principal != "" && (result.Signer == nil || result.Signer.Line != 2) {
+				t.Errorf("Verify returned signer %+v, expected the allowed signer on line 2", result.Signer)
+			}
+			if tt.status == StatusGood {
+				if result.Reason != "" || result.Err != nil {
+					t.Errorf("Verify returned a reason for a good signature: %s", result.Reason)
+				}
+			} else if result.Reason == "" || result.Err == nil {
+				t.Error("Verify returned no reason for a failure")
+			}
+			if tt.err != nil && !errors.Is(result.Err, tt.err) {
+				t.Errorf("Verify returned error %v, expected %v", result.Err, tt.err)
+			}
+			if tt.status != StatusError {
+				if result.KeyType != "ED25519" || result.Fingerprint != ssh.FingerprintSHA256(signer.PublicKey()) {
+					t.Errorf("Verify returned key %s %s", result.KeyType, result.Fingerprint)
+				}
+				if result.HashAlgorithm != "sha512" {
+					t.Errorf("Verify returned hash algorithm %s, expected sha512", result.HashAlgorithm)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyValidityWindow(t *testing.T) {
+	ca := testutil.NewSigner(t)
+	user := testutil.NewSigner(t)
+	data := []byte("data")
+
+	// The certificate expires before the allowed signer does, and becomes
+	// valid after it.
+	signerAfter := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	signerBefore := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	certAfter := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	certBefore := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	cert := newTestCertificate(t, ca, user.PublicKey(), []string{"test@example.com"}, certAfter, certBefore)
+
+	certSigner, err := ssh.NewCertSigner(cert, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := sshsig.Sign(certSigner.(ssh.AlgorithmSigner), "git", sshsig.DefaultHashAlgorithm, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := Verify(sshsig.Armor(signature), bytes.NewReader(data), Options{
+		AllowedSigners: []AllowedSigner{{
+			Email:      "*@example.com",
+			Principals: []string{"*@example.com"},
+			PublicKey:  testutil.AuthorizedKey(ca.PublicKey()),
+			Options:    SignerOptions{CertAuthority: true, ValidAfter: signerAfter, ValidBefore: signerBefore},
+			Line:       1,
+		}},
+		Principal: "test@example.com",
+		Namespace: "git",
+		Time:      time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+	})
+	if result.Status != StatusGood {
+		t.Fatalf("Verify returned status %s: %s", result.Status, result.Reason)
+	}
+	if result.ValidAfter == nil || !result.ValidAfter.Equal(certAfter) {
+		t.Errorf("Verify returned valid after %v, expected %v", result.ValidAfter, certAfter)
+	}
+	if result.ValidBefore == nil || !result.ValidBefore.Equal(certBefore) {
+		t.Errorf("Verify returned valid before %v, expected %v", result.ValidBefore, certBefore)
+	}
+	if result.KeyType != "ED25519-CERT" {
+		t.Errorf("Verify returned key type %s, expected ED25519-CERT", result.KeyType)
+	}
+}
+
+func TestResultText(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   Result
+		expected string
+	}{
+		{
+			name:     "good",
+			result:   Result{Status: StatusGood, Principal: "test@example.com", Namespace: "git", KeyType: "ED25519", Fingerprint: "SHA256:abc"},
+			expected: `Good "git" signature for test@example.com with ED25519 key SHA256:abc`,
+		},
+		{
+			name:     "good without principal",
+			result:   Result{Status: StatusGood, Namespace: "git", KeyType: "RSA", Fingerprint: "SHA256:abc"},
+			expected: "Good \"git\" signature with RSA key SHA256:abc\nNo matching principal",
+		},
+		{
+			name:     "bad signature",
+			result:   Result{Status: StatusBadSignature, Reason: "incorrect signature"},
+			expected: "Signature verification failed: incorrect signature",
+		},
+		{
+			name:     "revoked",
+			result:   Result{Status: StatusRevoked, Reason: "key is revoked: SHA256:abc"},
+			expected: "Signature key is revoked: SHA256:abc",
+		},
+		{
+			name:     "unknown signer",
+			result:   Result{Status: StatusUnknownSigner, Reason: "no principal matched"},
+			expected: "no principal matched",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if text := tt.result.Text(); text != tt.expected {
+				t.Errorf("Text returned %q, expected %q", text, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	validBefore := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	result := Result{
+		Status:        StatusExpired,
+		KeyType:       "ED25519",
+		Fingerprint:   "SHA256:abc",
+		Namespace:     "git",
+		HashAlgorithm: "sha512",
+		Reason:        "line 2: key has expired",
+		ValidBefore:   &validBefore,
+		Err:           ErrKeyExpired,
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"status":"expired","key_type":"ED25519","fingerprint":"SHA256:abc","namespace":"git","hash_algorithm":"sha512","reason":"line 2: key has expired","valid_before":"2024-02-01T00:00:00Z"}`
+	if string(b) != expected {
+		t.Errorf("json.Marshal returned %s, expected %s", b, expected)
+	}
+}
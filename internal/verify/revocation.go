@@ -0,0 +1,353 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	A revocation file is either a plain text file of revoked public keys, in
+	authorized_keys format, or an OpenSSH Key Revocation List (KRL). The KRL
+	format is documented at:
+	https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.krl
+*/
+
+var ErrKeyRevoked = errors.New("key is revoked")
+
+const krlMagic = "SSHKRL\n\x00"
+
+// KRL section types
+const (
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+)
+
+// KRL certificate section types
+const (
+	krlSectionCertSerialList   = 0x20
+	krlSectionCertSerialRange  = 0x21
+	krlSectionCertSerialBitmap = 0x22
+	krlSectionCertKeyID        = 0x23
+)
+
+type RevocationList struct {
+	// Marshalled public keys, without certificates.
+	keys map[string]bool
+	// Raw SHA1 and SHA256 hashes of marshalled public keys.
+	sha1   map[string]bool
+	sha256 map[string]bool
+	certs  []revokedCerts
+}
+
+// The certificates revoked for a certificate authority. If the CA is nil, the
+// revocations apply to certificates issued by any CA.
+type revokedCerts struct {
+	ca      ssh.PublicKey
+	serials []serialRange
+	bitmaps []serialBitmap
+	keyIDs  map[string]bool
+}
+
+type serialRange struct {
+	min, max uint64
+}
+
+type serialBitmap struct {
+	offset uint64
+	bitmap *big.Int
+}
+
+// Read the revocation file at the given path.
+func GetRevocationList(f string) (*RevocationList, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var rl *RevocationList
+	if bytes.HasPrefix(b, []byte(krlMagic)) {
+		rl, err = parseKRL(b)
+	} else {
+		rl, err = parseRevokedKeys(b, f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// Checks whether the key has been revoked. A certificate is revoked if the
+// key it certifies, its CA key, its serial number or its key ID is revoked.
+func (rl *RevocationList) CheckKey(key ssh.PublicKey) error {
+	cert, isCert := key.(*ssh.Certificate)
+	if isCert {
+		key = cert.Key
+	}
+	if rl.isKeyRevoked(key) {
+		return fmt.Errorf("%w: %s", ErrKeyRevoked, ssh.FingerprintSHA256(key))
+	}
+	if !isCert {
+		return nil
+	}
+
+	for _, rc := range rl.certs {
+		if rc.ca != nil && !bytes.Equal(rc.ca.Marshal(), cert.SignatureKey.Marshal()) {
+			continue
+		}
+		if rc.keyIDs[cert.KeyId] {
+			return fmt.Errorf("%w: certificate key ID '%s'", ErrKeyRevoked, cert.KeyId)
+		}
+		// Serial 0 is the default when the CA does not specify one, so it
+		// is never considered revoked.
+		if cert.Serial != 0 && rc.isSerialRevoked(cert.Serial) {
+			return fmt.Errorf("%w: certificate serial %d", ErrKeyRevoked, cert.Serial)
+		}
+	}
+
+	if rl.isKeyRevoked(cert.SignatureKey) {
+		return fmt.Errorf("%w: certificate authority %s", ErrKeyRevoked, ssh.FingerprintSHA256(cert.SignatureKey))
+	}
+	return nil
+}
+
+// Checks whether the plain key is revoked explicitly or by its fingerprint.
+func (rl *RevocationList) isKeyRevoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	sha1Sum := sha1.Sum(blob)
+	sha256Sum := sha256.Sum256(blob)
+	return rl.keys[string(blob)] || rl.sha1[string(sha1Sum[:])] || rl.sha256[string(sha256Sum[:])]
+}
+
+func (rc revokedCerts) isSerialRevoked(serial uint64) bool {
+	for _, r := range rc.serials {
+		if serial >= r.min && serial <= r.max {
+			return true
+		}
+	}
+	for _, b := range rc.bitmaps {
+		if serial >= b.offset && serial-b.offset < uint64(b.bitmap.BitLen()) &&
+			b.bitmap.Bit(int(serial-b.offset)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func newRevocationList() *RevocationList {
+	return &RevocationList{
+		keys:   map[string]bool{},
+		sha1:   map[string]bool{},
+		sha256: map[string]bool{},
+	}
+}
+
+// Parse a plain text file of revoked public keys. Empty lines and lines
+// starting with a '#' are ignored.
+func parseRevokedKeys(b []byte, name string) (*RevocationList, error) {
+	rl := newRevocationList()
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid public key: %w", name, lineNumber, err)
+		}
+		if cert, ok := key.(*ssh.Certificate); ok {
+			key = cert.Key
+		}
+		rl.keys[string(key.Marshal())] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rl, nil
+}
+
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.krl#L10
+type krlHeader struct {
+	Magic         uint64
+	FormatVersion uint32
+	KRLVersion    uint64
+	GeneratedDate uint64
+	Flags         uint64
+	Reserved      string
+	Comment       string
+	Sections      []byte `ssh:"rest"`
+}
+
+type krlSection struct {
+	Type byte
+	Data string
+	Rest []byte `ssh:"rest"`
+}
+
+// Parse a binary OpenSSH KRL. Signature sections are not verified and, as
+// they must follow all other sections, parsing stops at the first of them.
+func parseKRL(b []byte) (*RevocationList, error) {
+	header := krlHeader{}
+	if err := ssh.Unmarshal(b, &header); err != nil {
+		return nil, fmt.Errorf("invalid KRL: %w", err)
+	}
+	if header.FormatVersion != 1 {
+		return nil, fmt.Errorf("unsupported KRL format version: %d", header.FormatVersion)
+	}
+
+	rl := newRevocationList()
+	rest := header.Sections
+	for len(rest) > 0 {
+		section := krlSection{}
+		if err := ssh.Unmarshal(rest, &section); err != nil {
+			return nil, fmt.Errorf("invalid KRL section: %w", err)
+		}
+		rest = section.Rest
+		data := []byte(section.Data)
+
+		var err error
+		switch section.Type {
+		case krlSectionCertificates:
+			err = rl.parseCertificatesSection(data)
+		case krlSectionExplicitKey:
+			err = forEachString(data, func(s []byte) error {
+				key, err := ssh.ParsePublicKey(s)
+				if err != nil {
+					return err
+				}
+				rl.keys[string(key.Marshal())] = true
+				return nil
+			})
+		case krlSectionFingerprintSHA1:
+			err = forEachString(data, func(s []byte) error {
+				rl.sha1[string(s)] = true
+				return nil
+			})
+		case krlSectionFingerprintSHA256:
+			err = forEachString(data, func(s []byte) error {
+				rl.sha256[string(s)] = true
+				return nil
+			})
+		case krlSectionSignature:
+			return rl, nil
+		default:
+			err = fmt.Errorf("unsupported section type %d", section.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid KRL section: %w", err)
+		}
+	}
+
+	return rl, nil
+}
+
+type krlCertificatesHeader struct {
+	CAKey    string
+	Reserved string
+	Sections []byte `ssh:"rest"`
+}
+
+// Parse a KRL certificates section, which contains the revoked certificates
+// of a single CA.
+func (rl *RevocationList) parseCertificatesSection(b []byte) error {
+	header := krlCertificatesHeader{}
+	if err := ssh.Unmarshal(b, &header); err != nil {
+		return err
+	}
+
+	rc := revokedCerts{keyIDs: map[string]bool{}}
+	if header.CAKey != "" {
+		ca, err := ssh.ParsePublicKey([]byte(header.CAKey))
+		if err != nil {
+			return err
+		}
+		rc.ca = ca
+	}
+
+	rest := header.Sections
+	for len(rest) > 0 {
+		section := krlSection{}
+		if err := ssh.Unmarshal(rest, &section); err != nil {
+			return err
+		}
+		rest = section.Rest
+		data := []byte(section.Data)
+
+		switch section.Type {
+		case krlSectionCertSerialList:
+			if len(data)%8 != 0 {
+				return errors.New("invalid serial list")
+			}
+			for i := 0; i < len(data); i += 8 {
+				serial := binary.BigEndian.Uint64(data[i:])
+				rc.serials = append(rc.serials, serialRange{serial, serial})
+			}
+		case krlSectionCertSerialRange:
+			r := struct {
+				Min uint64
+				Max uint64
+			}{}
+			if err := ssh.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			rc.serials = append(rc.serials, serialRange{r.Min, r.Max})
+		case krlSectionCertSerialBitmap:
+			bm := struct {
+				Offset uint64
+				Bitmap *big.Int
+			}{}
+			if err := ssh.Unmarshal(data, &bm); err != nil {
+				return err
+			}
+			rc.bitmaps = append(rc.bitmaps, serialBitmap{bm.Offset, bm.Bitmap})
+		case krlSectionCertKeyID:
+			err := forEachString(data, func(s []byte) error {
+				rc.keyIDs[string(s)] = true
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported certificate section type %d", section.Type)
+		}
+	}
+
+	rl.certs = append(rl.certs, rc)
+	return nil
+}
+
+// Call fn with each of the consecutive SSH strings in b.
+func forEachString(b []byte, fn func([]byte) error) error {
+	for len(b) > 0 {
+		s := struct {
+			Value string
+			Rest  []byte `ssh:"rest"`
+		}{}
+		if err := ssh.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		if err := fn([]byte(s.Value)); err != nil {
+			return err
+		}
+		b = s.Rest
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package verify
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// Build a KRL section with the given type and data.
+func krlTestSection(t byte, data []byte) []byte {
+	return ssh.Marshal(struct {
+		Type byte
+		Data string
+	}{t, string(data)})
+}
+
+// Build a KRL containing the given sections.
+func krlTestFile(sections ...[]byte) []byte {
+	var rest []byte
+	for _, s := range sections {
+		rest = append(rest, s...)
+	}
+	return ssh.Marshal(krlHeader{
+		Magic:         binary.BigEndian.Uint64([]byte(krlMagic)),
+		FormatVersion: 1,
+		KRLVersion:    1,
+		GeneratedDate: uint64(time.Now().Unix()),
+		Sections:      rest,
+	})
+}
+
+// Build a KRL certificates section for the CA, with the given sub-sections.
+func krlTestCertificates(ca ssh.PublicKey, sections ...[]byte) []byte {
+	var caKey string
+	if ca != nil {
+		caKey = string(ca.Marshal())
+	}
+	var rest []byte
+	for _, s := range sections {
+		rest = append(rest, s...)
+	}
+	return krlTestSection(krlSectionCertificates, ssh.Marshal(krlCertificatesHeader{
+		CAKey:    caKey,
+		Sections: rest,
+	}))
+}
+
+// Write the revocation file to a temporary directory and load it.
+func loadTestRevocationList(t *testing.T, b []byte) *RevocationList {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), "revoked")
+	if err := os.WriteFile(f, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	rl, err := GetRevocationList(f)
+	if err != nil {
+		t.Fatalf("GetRevocationList returned an error: %v", err)
+	}
+	return rl
+}
+
+func TestRevokedKeysFile(t *testing.T) {
+	revoked := testutil.NewSigner(t)
+	other := testutil.NewSigner(t)
+
+	rl := loadTestRevocationList(t, []byte("# Lost laptop\n\n"+testutil.AuthorizedKey(revoked.PublicKey())+" old key\n"))
+
+	if err := rl.CheckKey(revoked.PublicKey()); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("CheckKey returned %v, expected %v", err, ErrKeyRevoked)
+	}
+	if err := rl.CheckKey(other.PublicKey()); err != nil {
+		t.Errorf("CheckKey returned an error for a key that is not revoked: %v", err)
+	}
+
+	// A certificate for a revoked key is also revoked
+	now := time.Now()
+	cert := newTestCertificate(t, other, revoked.PublicKey(), []string{"alice@example.com"}, now, now.Add(time.Hour))
+	if err := rl.CheckKey(cert); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("CheckKey returned %v, expected %v", err, ErrKeyRevoked)
+	}
+
+	// As is a certificate issued by a revoked CA
+	cert = newTestCertificate(t, revoked, other.PublicKey(), []string{"alice@example.com"}, now, now.Add(time.Hour))
+	if err := rl.CheckKey(cert); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("CheckKey returned %v, expected %v", err, ErrKeyRevoked)
+	}
+}
+
+func TestRevokedKeysFileInvalid(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "revoked")
+	if err := os.WriteFile(f, []byte("not a key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetRevocationList(f); err == nil {
+		t.Error("GetRevocationList returned no error, expected error")
+	}
+}
+
+func TestKRLKeys(t *testing.T) {
+	explicit := testutil.NewSigner(t)
+	byHash := testutil.NewSigner(t)
+	other := testutil.NewSigner(t)
+
+	hash := sha256.Sum256(byHash.PublicKey().Marshal())
+	rl := loadTestRevocationList(t, krlTestFile(
+		krlTestSection(krlSectionExplicitKey, ssh.Marshal(struct{ Key string }{string(explicit.PublicKey().Marshal())})),
+		krlTestSection(krlSectionFingerprintSHA256, ssh.Marshal(struct{ Hash string }{string(hash[:])})),
+	))
+
+	for _, tt := range []struct {
+		name    string
+		key     ssh.PublicKey
+		revoked bool
+	}{
+		{"explicit key", explicit.PublicKey(), true},
+		{"sha256 fingerprint", byHash.PublicKey(), true},
+		{"not revoked", other.PublicKey(), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rl.CheckKey(tt.key)
+			if errors.Is(err, ErrKeyRevoked) != tt.revoked {
+				t.Errorf("CheckKey returned %v, expected revoked: %v", err, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestKRLCertificates(t *testing.T) {
+	ca := testutil.NewSigner(t)
+	otherCA := testutil.NewSigner(t)
+	user := testutil.NewSigner(t)
+
+	bitmap := new(big.Int)
+	bitmap.SetBit(bitmap, 5, 1)
+
+	rl := loadTestRevocationList(t, krlTestFile(
+		krlTestCertificates(ca.PublicKey(),
+			krlTestSection(krlSectionCertSerialList, ssh.Marshal(struct{ A, B uint64 }{3, 7})),
+			krlTestSection(krlSectionCertSerialRange, ssh.Marshal(struct{ Min, Max uint64 }{100, 200})),
+			krlTestSection(krlSectionCertSerialBitmap, ssh.Marshal(struct {
+				Offset uint64
+				Bitmap *big.Int
+			}{1000, bitmap})),
+			krlTestSection(krlSectionCertKeyID, ssh.Marshal(struct{ ID string }{"lost-laptop"})),
+		),
+		// Key IDs revoked for any CA
+		krlTestCertificates(nil,
+			krlTestSection(krlSectionCertKeyID, ssh.Marshal(struct{ ID string }{"compromised"})),
+		),
+	))
+
+	now := time.Now()
+	newCert := func(ca ssh.Signer, serial uint64, keyID string) *ssh.Certificate {
+		cert := newTestCertificate(t, ca, user.PublicKey(), []string{"alice@example.com"}, now, now.Add(time.Hour))
+		cert.Serial = serial
+		cert.KeyId = keyID
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	for _, tt := range []struct {
+		name    string
+		cert    *ssh.Certificate
+		revoked bool
+	}{
+		{"serial in list", newCert(ca, 7, "test"), true},
+		{"serial in range", newCert(ca, 150, "test"), true},
+		{"serial in bitmap", newCert(ca, 1005, "test"), true},
+		{"serial not in bitmap", newCert(ca, 1004, "test"), false},
+		{"key ID", newCert(ca, 1, "lost-laptop"), true},
+		{"wildcard key ID", newCert(otherCA, 1, "compromised"), true},
+		{"serial of other CA", newCert(otherCA, 7, "test"), false},
+		{"not revoked", newCert(ca, 8, "test"), false},
+		{"zero serial", newCert(ca, 0, "test"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rl.CheckKey(tt.cert)
+			if errors.Is(err, ErrKeyRevoked) != tt.revoked {
+				t.Errorf("CheckKey returned %v, expected revoked: %v", err, tt.revoked)
+			}
+		})
+	}
+
+	// The plain key of a certificate is not revoked by its serial
+	if err := rl.CheckKey(user.PublicKey()); err != nil {
+		t.Errorf("CheckKey returned an error for a plain key: %v", err)
+	}
+}
+
+func TestKRLInvalid(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "revoked")
+	b := krlTestFile(krlTestSection(0x7f, nil))
+	if err := os.WriteFile(f, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetRevocationList(f); err == nil {
+		t.Error("GetRevocationList returned no error, expected error")
+	}
+}
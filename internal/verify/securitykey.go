@@ -0,0 +1,85 @@
+package verify
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	Signatures made with FIDO/U2F security keys (sk-ssh-ed25519@openssh.com
+	and sk-ecdsa-sha2-nistp256@openssh.com) carry a flags byte and a counter
+	reported by the authenticator, after the signature itself:
+	https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.u2f
+
+	The signature is made over a hash of the application, the flags, the
+	counter and the signed data, which ssh.PublicKey.Verify checks. The flags
+	record whether the user touched the key (user presence) and whether they
+	were verified, e.g. by a PIN (user verification).
+*/
+
+// Flags set by the authenticator in security key signatures.
+const (
+	SKUserPresent  = 0x01
+	SKUserVerified = 0x04
+)
+
+var (
+	ErrUserPresenceRequired     = errors.New("signature lacks the user presence flag")
+	ErrUserVerificationRequired = errors.New("signature lacks the user verification flag")
+)
+
+// The fields appended to a security key signature by the authenticator.
+type SecurityKeySignature struct {
+	Flags   byte
+	Counter uint32
+}
+
+// Returns true if the key, or the key certified by the certificate, is a
+// security key.
+func isSecurityKey(key ssh.PublicKey) bool {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	switch key.Type() {
+	case ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoSKED25519:
+		return true
+	}
+	return false
+}
+
+// Unpack the flags and counter from a security key signature.
+func parseSecurityKeySignature(sig *ssh.Signature) (*SecurityKeySignature, error) {
+	sks := SecurityKeySignature{}
+	if err := ssh.Unmarshal(sig.Rest, &sks); err != nil {
+		return nil, errors.New("invalid security key signature: missing flags and counter")
+	}
+	return &sks, nil
+}
+
+// Checks the flags of a security key signature against the options of the
+// allowed signer. As with sshd, user presence is required unless the allowed
+// signer has the no-touch-required option, or the signature was made with a
+// certificate that has the no-touch-required extension. User verification is
+// only required with the verify-required option. Signatures made with other
+// keys are always accepted.
+func (as AllowedSigner) checkSecurityKey(sig *Signature) error {
+	if sig.SecurityKey == nil {
+		return nil
+	}
+
+	noTouchRequired := as.Options.NoTouchRequired
+	if cert, ok := sig.PublicKey.(*ssh.Certificate); ok {
+		if _, ok := cert.Extensions["no-touch-required"]; ok {
+			noTouchRequired = true
+		}
+	}
+
+	if !noTouchRequired && sig.SecurityKey.Flags&SKUserPresent == 0 {
+		return ErrUserPresenceRequired
+	}
+	if as.Options.VerifyRequired && sig.SecurityKey.Flags&SKUserVerified == 0 {
+		return ErrUserVerificationRequired
+	}
+	return nil
+}
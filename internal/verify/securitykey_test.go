@@ -0,0 +1,236 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
+	"github.com/Keeper-Security/git-ssh-sign/internal/testutil"
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
+	"golang.org/x/crypto/ssh"
+)
+
+// A software stand-in for a FIDO authenticator, producing signatures in the
+// same encoding as a hardware security key.
+type testSecurityKey struct {
+	publicKey ssh.PublicKey
+	sign      func(digest []byte) []byte
+}
+
+const testApplication = "ssh:"
+
+func newTestSKEd25519(t *testing.T) *testSecurityKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, pub, testApplication}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSecurityKey{
+		publicKey: key,
+		sign: func(data []byte) []byte {
+			return ed25519.Sign(priv, data)
+		},
+	}
+}
+
+func newTestSKECDSA(t *testing.T) *testSecurityKey {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Name        string
+		Curve       string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKECDSA256, "nistp256", elliptic.Marshal(elliptic.P256(), priv.X, priv.Y), testApplication}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSecurityKey{
+		publicKey: key,
+		sign: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ssh.Marshal(struct{ R, S *big.Int }{r, s})
+		},
+	}
+}
+
+// Create an armored SSH signature of the data, as made by the authenticator
+// with the given flags and counter.
+func (k *testSecurityKey) armor(data []byte, flags byte, counter uint32) []byte {
+	h := sha512.Sum512(data)
+	message := append([]byte(sshsig.MagicHeader), ssh.Marshal(sshsig.MessageWrapper{
+		Namespace:     sign.DefaultNamespace,
+		HashAlgorithm: sshsig.DefaultHashAlgorithm,
+		Hash:          string(h[:]),
+	})...)
+
+	appDigest := sha256.Sum256([]byte(testApplication))
+	messageDigest := sha256.Sum256(message)
+	signed := ssh.Marshal(struct {
+		ApplicationDigest []byte `ssh:"rest"`
+		Flags             byte
+		Counter           uint32
+		MessageDigest     []byte `ssh:"rest"`
+	}{appDigest[:], flags, counter, messageDigest[:]})
+
+	sig := &ssh.Signature{
+		Format: k.publicKey.Type(),
+		Blob:   k.sign(signed),
+		Rest:   ssh.Marshal(SecurityKeySignature{flags, counter}),
+	}
+
+	wrapped := sshsig.WrappedSig{
+		Version:       1,
+		PublicKey:     string(k.publicKey.Marshal()),
+		Namespace:     sign.DefaultNamespace,
+		HashAlgorithm: sshsig.DefaultHashAlgorithm,
+		Signature:     string(ssh.Marshal(sig)),
+	}
+	copy(wrapped.MagicHeader[:], sshsig.MagicHeader)
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "SSH SIGNATURE",
+		Bytes: ssh.Marshal(wrapped),
+	})
+}
+
+func TestSecurityKeySignatures(t *testing.T) {
+	data := []byte("Hello, security key!")
+
+	for _, tt := range []struct {
+		name    string
+		key     *testSecurityKey
+		keyType string
+	}{
+		{"sk-ssh-ed25519", newTestSKEd25519(t), "ED25519-SK"},
+		{"sk-ecdsa-sha2-nistp256", newTestSKECDSA(t), "ECDSA-SK"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := Decode(tt.key.armor(data, SKUserPresent, 42))
+			if err != nil {
+				t.Fatalf("Decode returned an error: %v", err)
+			}
+			if sig.SecurityKey == nil || sig.SecurityKey.Flags != SKUserPresent || sig.SecurityKey.Counter != 42 {
+				t.Errorf("Decode returned security key fields %+v, expected flags 1 and counter 42", sig.SecurityKey)
+			}
+			if KeyType(sig.PublicKey) != tt.keyType {
+				t.Errorf("KeyType returned %s, expected %s", KeyType(sig.PublicKey), tt.keyType)
+			}
+
+			if err := VerifyMessage(sig, bytes.NewReader(data), sign.DefaultNamespace); err != nil {
+				t.Errorf("VerifyMessage returned an error: %v", err)
+			}
+			if err := VerifyMessage(sig, strings.NewReader("tampered"), sign.DefaultNamespace); err == nil {
+				t.Error("VerifyMessage returned no error for tampered data")
+			}
+
+			// Changing the flags invalidates the signature
+			forged := *sig.Signature
+			forged.Rest = ssh.Marshal(SecurityKeySignature{SKUserPresent | SKUserVerified, 42})
+			if err := VerifyMessage(&Signature{
+				Signature:     &forged,
+				PublicKey:     sig.PublicKey,
+				Namespace:     sig.Namespace,
+				HashAlgorithm: sig.HashAlgorithm,
+			}, bytes.NewReader(data), sign.DefaultNamespace); err == nil {
+				t.Error("VerifyMessage returned no error for forged flags")
+			}
+		})
+	}
+}
+
+func TestSecurityKeyOptions(t *testing.T) {
+	key := newTestSKEd25519(t)
+	data := []byte("Hello, security key!")
+
+	for _, tt := range []struct {
+		name     string
+		flags    byte
+		options  SignerOptions
+		expected error
+	}{
+		{
+			name:  "user present",
+			flags: SKUserPresent,
+		},
+		{
+			name:     "no user presence",
+			flags:    0,
+			expected: ErrUserPresenceRequired,
+		},
+		{
+			name:    "no user presence with no-touch-required",
+			flags:   0,
+			options: SignerOptions{NoTouchRequired: true},
+		},
+		{
+			name:     "no user verification with verify-required",
+			flags:    SKUserPresent,
+			options:  SignerOptions{VerifyRequired: true},
+			expected: ErrUserVerificationRequired,
+		},
+		{
+			name:    "user verified with verify-required",
+			flags:   SKUserPresent | SKUserVerified,
+			options: SignerOptions{VerifyRequired: true},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := Decode(key.armor(data, tt.flags, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signers := []AllowedSigner{
+				{
+					Email:      "alice@example.com",
+					Principals: []string{"alice@example.com"},
+					Options:    tt.options,
+					PublicKey:  testutil.AuthorizedKey(key.publicKey),
+					Line:       1,
+				},
+			}
+
+			_, err = FindSigner(signers, "alice@example.com", sig, sign.DefaultNamespace, time.Now())
+			if !errors.Is(err, tt.expected) || (err != nil) != (tt.expected != nil) {
+				t.Errorf("FindSigner returned %v, expected %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseSecurityKeyOptions(t *testing.T) {
+	as, err := parseAllowedSignerLine("alice@example.com no-touch-required,verify-required " + ed25519PublicKey)
+	if err != nil {
+		t.Fatalf("parseAllowedSignerLine returned an error: %v", err)
+	}
+	if !as.Options.NoTouchRequired || !as.Options.VerifyRequired {
+		t.Errorf("parseAllowedSignerLine returned options %+v, expected no-touch-required and verify-required", as.Options)
+	}
+}
@@ -1,134 +1,250 @@
 package verify
 
 import (
-	"bufio"
 	"bytes"
-	"crypto/sha256"
-	"crypto/sha512"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
 	"golang.org/x/crypto/ssh"
 )
 
-type AllowedSigner struct {
-	Email     string
-	PublicKey string
-}
-
 type Signature struct {
 	Signature     *ssh.Signature
 	PublicKey     ssh.PublicKey
+	Namespace     string
 	HashAlgorithm string
+	// Only set for signatures made with a security key.
+	SecurityKey *SecurityKeySignature
 }
 
-var supportedHashAlgorithms = map[string]func() hash.Hash{
-	"sha256": sha256.New,
-	"sha512": sha512.New,
-}
+var (
+	ErrNoPrincipalMatched    = errors.New("no principal matched")
+	ErrNamespaceNotPermitted = errors.New("key is not permitted for use in signature namespace")
+	ErrPrincipalNotPermitted = errors.New("signer is not a permitted principal")
+)
 
 // Decodes a PEM encoded signature into a Signature struct. If invalid or
 // unsupported data is found, an error is returned, even if the signature is
 // valid for other use cases outside of the restrictions of this program.
 func Decode(b []byte) (*Signature, error) {
-	pemBlock, _ := pem.Decode(b)
-	if pemBlock == nil {
-		return nil, errors.New("unable to decode pem file")
+	sig, err := sshsig.Unarmor(b)
+	if err != nil {
+		return nil, err
 	}
 
-	if pemBlock.Type != "SSH SIGNATURE" {
-		return nil, fmt.Errorf("unsupported block type '%s'", pemBlock.Type)
+	var sks *SecurityKeySignature
+	if isSecurityKey(sig.PublicKey) {
+		sks, err = parseSecurityKeySignature(sig.Signature)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Unmarshal into the Signature block
-	sig := sign.WrappedSig{}
-	if err := ssh.Unmarshal(pemBlock.Bytes, &sig); err != nil {
-		return nil, err
+	return &Signature{
+		Signature:     sig.Signature,
+		PublicKey:     sig.PublicKey,
+		Namespace:     sig.Namespace,
+		HashAlgorithm: sig.HashAlgorithm,
+		SecurityKey:   sks,
+	}, nil
+}
+
+// Verifies the signature against the given data with the public key embedded
+// in the signature.
+func VerifyMessage(sig *Signature, data io.Reader, namespace string) error {
+	s := &sshsig.Signature{
+		Signature:     sig.Signature,
+		PublicKey:     sig.PublicKey,
+		Namespace:     sig.Namespace,
+		HashAlgorithm: sig.HashAlgorithm,
 	}
+	return sshsig.Verify(s, sig.PublicKey, namespace, data)
+}
 
-	// Validation of the Signature block is done before we can unpack the
-	// Signature and PublicKey blocks. This ensures that we don't unpack
-	// malicious, invalid, or unsupported data. Instead, we can return an
-	// error before we do any unpacking.
-	if sig.Version != 1 {
-		return nil, fmt.Errorf("unsupported signature version: %d", sig.Version)
+// An allowed signer that authorizes a key, along with the principals the key
+// is authorized for. For plain keys these are the principals of the allowed
+// signer; for certificates they are the principals of the certificate that
+// the allowed signer accepts.
+type Match struct {
+	Signer     AllowedSigner
+	Principals []string
+}
+
+// Finds the allowed signers that authorize the given key at the given time.
+// If the key is only matched by allowed signers that reject it, e.g. because
+// they are outside of their validity window, the reason the last of them
+// rejected the key is returned as an error.
+func FindSigners(as []AllowedSigner, key ssh.PublicKey, t time.Time) ([]Match, error) {
+	var matches []Match
+	var rejectErr error
+	for _, p := range as {
+		principals, err := p.authorize(key, t)
+		if err != nil {
+			rejectErr = fmt.Errorf("line %d: %w", p.Line, err)
+			continue
+		}
+		if len(principals) > 0 {
+			matches = append(matches, Match{Signer: p, Principals: principals})
+		}
 	}
-	if string(sig.MagicHeader[:]) != sign.MagicHeader {
-		return nil, fmt.Errorf("invalid magic header: '%s'", sig.MagicHeader[:])
+	if len(matches) == 0 && rejectErr != nil {
+		return nil, rejectErr
 	}
-	if sig.Namespace != sign.Namespace {
-		return nil, fmt.Errorf("invalid signature namespace: '%s'", sig.Namespace)
+	return matches, nil
+}
+
+// Checks whether the allowed signer authorizes the given key at the given
+// time, returning the principals it is authorized for. A plain key must be
+// identical to the key of the allowed signer, while a certificate must have
+// been issued by it, when it is marked as a cert-authority. No principals and
+// no error are returned if the allowed signer does not apply to the key.
+func (as AllowedSigner) authorize(key ssh.PublicKey, t time.Time) ([]string, error) {
+	// Parse into SSH wire format
+	authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(as.PublicKey))
+	if err != nil {
+		return nil, err
 	}
-	if _, ok := supportedHashAlgorithms[sig.HashAlgorithm]; !ok {
-		return nil, fmt.Errorf("unsupported hash algorithm: '%s'", sig.HashAlgorithm)
+
+	cert, isCert := key.(*ssh.Certificate)
+	if as.Options.CertAuthority != isCert {
+		return nil, nil
+	}
+
+	var principals []string
+	if isCert {
+		if !bytes.Equal(cert.SignatureKey.Marshal(), authorizedKey.Marshal()) {
+			return nil, nil
+		}
+		principals = certPrincipals(cert, as.Principals)
+		if len(principals) == 0 {
+			return nil, nil
+		}
+		if err := checkCertificate(cert, authorizedKey, t); err != nil {
+			return nil, err
+		}
+	} else {
+		if !bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+			return nil, nil
+		}
+		principals = as.Principals
 	}
 
-	// Now we can unpack the Signature and PublicKey blocks
-	signature := ssh.Signature{}
-	if err := ssh.Unmarshal([]byte(sig.Signature), &signature); err != nil {
+	if err := as.ValidAt(t); err != nil {
 		return nil, err
 	}
+	return principals, nil
+}
 
-	publicKey, err := ssh.ParsePublicKey([]byte(sig.PublicKey))
+// Finds matching principals for the given signature that are valid at the
+// given time. The principals of each matching allowed signer are returned,
+// comma-separated, in the same form as ssh-keygen.
+func GetMatchingPrincipals(as []AllowedSigner, signature *Signature, t time.Time) ([]string, error) {
+	matches, err := FindSigners(as, signature.PublicKey, t)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Signature{
-		Signature:     &signature,
-		PublicKey:     publicKey,
-		HashAlgorithm: sig.HashAlgorithm,
-	}, nil
+	var matchingPrincipals []string
+	for _, m := range matches {
+		matchingPrincipals = append(matchingPrincipals, strings.Join(m.Principals, ","))
+	}
+	return matchingPrincipals, nil
 }
 
-// Finds matching principals for the given signature.
-func GetMatchingPrincipals(as []AllowedSigner, signature *Signature) ([]string, error) {
-	var matchingPrincipals []string
+// Finds the allowed signer that authorizes the key of the signature to sign
+// as the given principal, in the given namespace, at the given time. The
+// principal must match the principals pattern-list of the allowed signer and,
+// for a certificate, must also be one of the principals of the certificate.
+// If every allowed signer for the principal rejects the signature, the reason
+// the last of them rejected it is returned as an error.
+func FindSigner(as []AllowedSigner, principal string, sig *Signature, namespace string, t time.Time) (*AllowedSigner, error) {
+	key := sig.PublicKey
+	cert, isCert := key.(*ssh.Certificate)
+
+	var rejectErr error
 	for _, p := range as {
-		// Parse into SSH wire format
-		authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(p.PublicKey))
+		if !MatchPatternList(principal, p.Principals) {
+			continue
+		}
+
+		principals, err := p.authorize(key, t)
 		if err != nil {
-			return nil, err
+			rejectErr = fmt.Errorf("line %d: %w", p.Line, err)
+			continue
 		}
-		if bytes.Equal(signature.PublicKey.Marshal(), authorizedKey.Marshal()) {
-			matchingPrincipals = append(matchingPrincipals, p.PublicKey)
+		if len(principals) == 0 || (isCert && !contains(cert.ValidPrincipals, principal)) {
+			continue
+		}
+
+		if len(p.Options.Namespaces) > 0 && !MatchPatternList(namespace, p.Options.Namespaces) {
+			rejectErr = fmt.Errorf("line %d: %w \"%s\"", p.Line, ErrNamespaceNotPermitted, namespace)
+			continue
 		}
+
+		if err := p.checkSecurityKey(sig); err != nil {
+			rejectErr = fmt.Errorf("line %d: %w", p.Line, err)
+			continue
+		}
+
+		return &p, nil
 	}
-	return matchingPrincipals, nil
+
+	if rejectErr != nil {
+		return nil, rejectErr
+	}
+	return nil, ErrNoPrincipalMatched
 }
 
-// Parse a given file and returns a slice of AllowedSigners.
-// This only supports one email address per public key, currently.
-func GetAllowedSigners(f string) ([]AllowedSigner, error) {
-	asf, err := os.Open(f)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	defer asf.Close()
-
-	var allowedSigners []AllowedSigner
-	scanner := bufio.NewScanner(asf)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
-		as := AllowedSigner{
-			Email:     fields[0],
-			PublicKey: fields[1] + " " + fields[2],
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
 		}
-		allowedSigners = append(allowedSigners, as)
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	return false
+}
+
+// Returns the name ssh-keygen uses for the type of the key, e.g. "ED25519" or
+// "RSA-CERT".
+func KeyType(key ssh.PublicKey) string {
+	suffix := ""
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+		suffix = "-CERT"
 	}
 
-	return allowedSigners, nil
+	var name string
+	switch key.Type() {
+	case ssh.KeyAlgoRSA:
+		name = "RSA"
+	case ssh.KeyAlgoDSA:
+		name = "DSA"
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		name = "ECDSA"
+	case ssh.KeyAlgoED25519:
+		name = "ED25519"
+	case ssh.KeyAlgoSKECDSA256:
+		name = "ECDSA-SK"
+	case ssh.KeyAlgoSKED25519:
+		name = "ED25519-SK"
+	default:
+		name = strings.ToUpper(key.Type())
+	}
+	return name + suffix
+}
+
+// Returns the SHA256 fingerprint of the key. The fingerprint of a
+// certificate is that of the key it certifies, matching ssh-keygen.
+func Fingerprint(key ssh.PublicKey) string {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	return ssh.FingerprintSHA256(key)
 }
 
 // Parse a given signature from a file and returns a Signature struct.
@@ -151,21 +267,3 @@ func ParseSignatureFile(filepath string) (*Signature, error) {
 
 	return sig, nil
 }
-
-// Compares the fingerprint of the principal with the public key in the
-// signature.
-func VerifyFingerprints(principal []byte, pubKey ssh.PublicKey) error {
-	// Parse into wire format
-	key, _, _, _, err := ssh.ParseAuthorizedKey(principal)
-	if err != nil {
-		return err
-	}
-
-	principalHash := []byte(ssh.FingerprintSHA256(key))
-	pubKeyHash := []byte(ssh.FingerprintSHA256(pubKey))
-
-	if bytes.Equal(principalHash, pubKeyHash) {
-		return nil
-	}
-	return errors.New("fingerprint does not match")
-}
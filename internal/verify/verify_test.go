@@ -3,12 +3,15 @@ package verify
 import (
 	"bytes"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/Keeper-Security/git-ssh-sign/internal/sign"
+	"github.com/Keeper-Security/git-ssh-sign/pkg/sshsig"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -74,12 +77,16 @@ MQUjv26NIZPFqtAAAADHRlc3RAZXhhbXBsZQECAwQFBg==
 var (
 	allowedSigners = []AllowedSigner{
 		{
-			Email:     "test@example.com",
-			PublicKey: ed25519PublicKey,
+			Email:      "test@example.com",
+			Principals: []string{"test@example.com"},
+			PublicKey:  ed25519PublicKey,
+			Line:       1,
 		},
 		{
-			Email:     "test@example.com",
-			PublicKey: rsaPublicKey,
+			Email:      "test@example.com",
+			Principals: []string{"test@example.com"},
+			PublicKey:  rsaPublicKey,
+			Line:       2,
 		},
 	}
 )
@@ -95,18 +102,113 @@ func TestGetMatchingPrincipals(t *testing.T) {
 	}
 
 	// Find matching principals
-	matchingPrincipals, err := GetMatchingPrincipals(allowedSigners, sig)
+	matchingPrincipals, err := GetMatchingPrincipals(allowedSigners, sig, time.Now())
 	if err != nil {
 		t.Fatalf("GetMatchingPrincipals returned an error: %v", err)
 	}
 
 	// Check that the correct principals were found
-	expectedPrincipals := []string{allowedSigners[0].PublicKey}
+	expectedPrincipals := []string{allowedSigners[0].Email}
 	if !reflect.DeepEqual(matchingPrincipals, expectedPrincipals) {
 		t.Errorf("GetMatchingPrincipals returned %v, expected %v", matchingPrincipals, expectedPrincipals)
 	}
 }
 
+func TestGetMatchingPrincipalsValidity(t *testing.T) {
+	principal, _, _, _, err := ssh.ParseAuthorizedKey([]byte(ed25519PublicKey))
+	if err != nil {
+		t.Fatalf("Failed to parse test principal: %v", err)
+	}
+
+	sig := &Signature{
+		PublicKey: principal,
+	}
+
+	expired := []AllowedSigner{
+		{
+			Email:      "test@example.com",
+			Principals: []string{"test@example.com"},
+			Options: SignerOptions{
+				ValidBefore: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			PublicKey: ed25519PublicKey,
+			Line:      1,
+		},
+	}
+
+	// The key was valid at the time of signing
+	matchingPrincipals, err := GetMatchingPrincipals(expired, sig, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(matchingPrincipals) != 1 {
+		t.Errorf("GetMatchingPrincipals returned %v, %v, expected one principal", matchingPrincipals, err)
+	}
+
+	// The key has since expired
+	_, err = GetMatchingPrincipals(expired, sig, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if !errors.Is(err, ErrKeyExpired) {
+		t.Errorf("GetMatchingPrincipals returned %v, expected %v", err, ErrKeyExpired)
+	}
+}
+
+func TestFindSigner(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(ed25519PublicKey))
+	if err != nil {
+		t.Fatalf("Failed to parse test key: %v", err)
+	}
+
+	signers := []AllowedSigner{
+		{
+			Email:      "alice@example.com,bob@example.com",
+			Principals: []string{"alice@example.com", "bob@example.com"},
+			PublicKey:  ed25519PublicKey,
+			Line:       1,
+		},
+		{
+			Email:      "*@example.org,!mallory@example.org",
+			Principals: []string{"*@example.org", "!mallory@example.org"},
+			PublicKey:  ed25519PublicKey,
+			Line:       2,
+		},
+		{
+			Email:      "carol@example.com",
+			Principals: []string{"carol@example.com"},
+			Options:    SignerOptions{Namespaces: []string{"file"}},
+			PublicKey:  ed25519PublicKey,
+			Line:       3,
+		},
+		{
+			Email:      "dave@example.com",
+			Principals: []string{"dave@example.com"},
+			PublicKey:  rsaPublicKey,
+			Line:       4,
+		},
+	}
+
+	for _, tt := range []struct {
+		principal string
+		namespace string
+		line      int
+		expected  error
+	}{
+		{principal: "alice@example.com", namespace: "git", line: 1},
+		{principal: "bob@example.com", namespace: "git", line: 1},
+		{principal: "anyone@example.org", namespace: "git", line: 2},
+		{principal: "carol@example.com", namespace: "file", line: 3},
+		{principal: "carol@example.com", namespace: "git", expected: ErrNamespaceNotPermitted},
+		{principal: "mallory@example.org", namespace: "git", expected: ErrNoPrincipalMatched},
+		{principal: "dave@example.com", namespace: "git", expected: ErrNoPrincipalMatched},
+	} {
+		t.Run(tt.principal+"/"+tt.namespace, func(t *testing.T) {
+			as, err := FindSigner(signers, tt.principal, &Signature{PublicKey: key}, tt.namespace, time.Now())
+			if !errors.Is(err, tt.expected) || (err != nil) != (tt.expected != nil) {
+				t.Fatalf("FindSigner returned %v, expected %v", err, tt.expected)
+			}
+			if err == nil && as.Line != tt.line {
+				t.Errorf("FindSigner returned line %d, expected %d", as.Line, tt.line)
+			}
+		})
+	}
+}
+
 func TestGetAllowedSigners(t *testing.T) {
 	// Create a test allowed_signers file
 	f, err := os.CreateTemp(os.TempDir(), "allowed_signers-*")
@@ -130,20 +232,6 @@ func TestGetAllowedSigners(t *testing.T) {
 	}
 }
 
-func TestVerifyFingerprints(t *testing.T) {
-	// Create a test principal and public key
-	principal := []byte(ed25519PublicKey)
-	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(principal)
-	if err != nil {
-		t.Fatalf("Failed to parse test principal: %v", err)
-	}
-
-	// Verify the principal is the same as the one used to sign the data
-	if err := VerifyFingerprints(principal, pubKey); err != nil {
-		t.Errorf("VerifyFingerprints returned an error: %v", err)
-	}
-}
-
 func TestVerifySignature(t *testing.T) {
 	data := []byte("Hello, git-ssh-sign!")
 	otherSSHPublicKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB2ZzQ8p3/T61CSfhzH9IDhvkLP95OZ9vjwFOFOWH64Y"
@@ -178,28 +266,196 @@ func TestVerifySignature(t *testing.T) {
 			}
 
 			// Create signature for the data in `data`
-			signature, err := sign.NewSignature(as, bytes.NewReader(data))
+			signature, err := sshsig.Sign(as, sign.DefaultNamespace, sshsig.DefaultHashAlgorithm, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decodedSignature, err := Decode(sshsig.Armor(signature))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The signature carries the public key used to sign the data
+			pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(tt.pub))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ssh.FingerprintSHA256(decodedSignature.PublicKey) != ssh.FingerprintSHA256(pub) {
+				t.Errorf("Decode returned key %s, expected %s", ssh.FingerprintSHA256(decodedSignature.PublicKey), ssh.FingerprintSHA256(pub))
+			}
+
+			// And not a different one
+			other, _, _, _, err := ssh.ParseAuthorizedKey([]byte(otherSSHPublicKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ssh.FingerprintSHA256(decodedSignature.PublicKey) == ssh.FingerprintSHA256(other) {
+				t.Error("Decode returned the key of another signer")
+			}
+		})
+	}
+
+}
+
+func TestVerifyMessage(t *testing.T) {
+	data := []byte("Hello, git-ssh-sign!")
+
+	for _, tt := range []struct {
+		name string
+		priv string
+	}{
+		{
+			name: "rsa",
+			priv: rsaPrivateKey,
+		},
+		{
+			name: "ed25519",
+			priv: ed25519PrivateKey,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt := tt
+
+			s, err := ssh.ParsePrivateKey([]byte(tt.priv))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			as, ok := s.(ssh.AlgorithmSigner)
+			if !ok {
+				t.Fatal(err)
+			}
+
+			signature, err := sshsig.Sign(as, sign.DefaultNamespace, sshsig.DefaultHashAlgorithm, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sig, err := Decode(sshsig.Armor(signature))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The unmodified data should verify
+			if err := VerifyMessage(sig, bytes.NewReader(data), sign.DefaultNamespace); err != nil {
+				t.Errorf("VerifyMessage returned an error: %v", err)
+			}
+
+			// Tampered data should fail
+			tampered := append([]byte{}, data...)
+			tampered[0] ^= 0xff
+			if err := VerifyMessage(sig, bytes.NewReader(tampered), sign.DefaultNamespace); err == nil {
+				t.Error("VerifyMessage returned no error for tampered data")
+			}
+
+			// A different namespace should fail
+			if err := VerifyMessage(sig, bytes.NewReader(data), "file"); err == nil {
+				t.Error("VerifyMessage returned no error for a different namespace")
+			}
+		})
+	}
+}
+
+func TestVerifyMessageHashAlgorithm(t *testing.T) {
+	data := []byte("release-1.0.0.tar.gz")
+
+	for _, tt := range []struct {
+		name      string
+		priv      string
+		hashAlg   string
+		algorithm string
+	}{
+		{"rsa-sha256", rsaPrivateKey, sshsig.HashSHA256, ssh.KeyAlgoRSASHA256},
+		{"rsa-sha512", rsaPrivateKey, sshsig.HashSHA512, ssh.KeyAlgoRSASHA512},
+		{"ed25519-sha256", ed25519PrivateKey, sshsig.HashSHA256, ssh.KeyAlgoED25519},
+		{"ed25519-sha512", ed25519PrivateKey, sshsig.HashSHA512, ssh.KeyAlgoED25519},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			as, err := sign.ParsePrivateKey(tt.priv, "")
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			decodedSignature, err := Decode(sign.Armor(signature, s.PublicKey()))
+			armored, err := sign.Sign(as, "file", tt.hashAlg, bytes.NewReader(data))
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			// Verify the principal is the same as the one used to sign the data
-			if err := VerifyFingerprints([]byte(tt.pub), decodedSignature.PublicKey); err != nil {
-				t.Error(err)
+			sig, err := Decode(armored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sig.HashAlgorithm != tt.hashAlg || sig.Signature.Format != tt.algorithm {
+				t.Errorf("Decode returned hash algorithm %s and signature algorithm %s", sig.HashAlgorithm, sig.Signature.Format)
 			}
 
-			// Should fail with a different principal used to sign the data
-			if err := VerifyFingerprints([]byte(otherSSHPublicKey), decodedSignature.PublicKey); err == nil {
-				t.Error("expected error!")
+			if err := VerifyMessage(sig, bytes.NewReader(data), "file"); err != nil {
+				t.Errorf("VerifyMessage returned an error: %v", err)
+			}
+
+			// The hash algorithm is signed, so it cannot be changed.
+			forged := *sig
+			forged.HashAlgorithm = sshsig.HashSHA256
+			if tt.hashAlg == sshsig.HashSHA256 {
+				forged.HashAlgorithm = sshsig.HashSHA512
+			}
+			if err := VerifyMessage(&forged, bytes.NewReader(data), "file"); err == nil {
+				t.Error("VerifyMessage returned no error for a changed hash algorithm")
 			}
 		})
 	}
+}
+
+func TestVerifyMessageNamespace(t *testing.T) {
+	data := []byte("release-1.0.0.tar.gz")
+
+	s, err := ssh.ParsePrivateKey([]byte(ed25519PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	as, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		t.Fatal(err)
+	}
 
+	for _, namespace := range []string{"file", "release@example.com"} {
+		t.Run(namespace, func(t *testing.T) {
+			signature, err := sshsig.Sign(as, namespace, sshsig.DefaultHashAlgorithm, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sig, err := Decode(sshsig.Armor(signature))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sig.Namespace != namespace {
+				t.Errorf("Decode returned namespace %s, expected %s", sig.Namespace, namespace)
+			}
+
+			if err := VerifyMessage(sig, bytes.NewReader(data), namespace); err != nil {
+				t.Errorf("VerifyMessage returned an error: %v", err)
+			}
+
+			// The signature must not verify in the git namespace
+			if err := VerifyMessage(sig, bytes.NewReader(data), sign.DefaultNamespace); err == nil {
+				t.Error("VerifyMessage returned no error for the git namespace")
+			}
+
+			// Even if the namespace in the armored signature is changed
+			forged := *signature
+			forged.Namespace = sign.DefaultNamespace
+			forgedSig, err := Decode(sshsig.Armor(&forged))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyMessage(forgedSig, bytes.NewReader(data), sign.DefaultNamespace); err == nil {
+				t.Error("VerifyMessage returned no error for a forged namespace")
+			}
+		})
+	}
 }
 
 func TestValidDecode(t *testing.T) {
@@ -234,13 +490,13 @@ func TestValidDecode(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			signature, err := sign.NewSignature(as, bytes.NewReader(data))
+			signature, err := sshsig.Sign(as, sign.DefaultNamespace, sshsig.DefaultHashAlgorithm, bytes.NewReader(data))
 			if err != nil {
 				t.Fatal(err)
 			}
 
 			// Check that Decode returns no error when given a valid signature
-			_, err = Decode(sign.Armor(signature, s.PublicKey()))
+			_, err = Decode(sshsig.Armor(signature))
 			if err != nil {
 				t.Fatalf("Decode returned an error: %v", err)
 			}
@@ -288,14 +544,14 @@ func TestInvalidDecode(t *testing.T) {
 			}
 
 			// Create a signature for the data in `data`
-			sig, err := sign.NewSignature(as, f)
+			sig, err := sshsig.Sign(as, sign.DefaultNamespace, sshsig.DefaultHashAlgorithm, f)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			// Wrap the signature in a MessageWrapper with an invalid namespace
+			// Wrap the signature in a MessageWrapper with an empty namespace
 			// and test it fails
-			swn := CreateInvalidArmor(1, string(tt.pub), "INVALID", sign.DefaultHashAlgorithm, sig, sign.MagicHeader)
+			swn := CreateInvalidArmor(1, string(tt.pub), "", sshsig.DefaultHashAlgorithm, sig.Signature, sshsig.MagicHeader)
 			_, err = Decode(swn)
 			if err == nil {
 				t.Fatalf("Decode returned no error, expected error")
@@ -303,7 +559,7 @@ func TestInvalidDecode(t *testing.T) {
 
 			// Wrap the signature in a MessageWrapper with an invalid hash and
 			// test it fails
-			swh := CreateInvalidArmor(1, string(tt.pub), sign.Namespace, "INVALID", sig, sign.MagicHeader)
+			swh := CreateInvalidArmor(1, string(tt.pub), sign.DefaultNamespace, "INVALID", sig.Signature, sshsig.MagicHeader)
 			_, err = Decode(swh)
 			if err == nil {
 				t.Fatalf("Decode returned no error, expected error")
@@ -311,7 +567,7 @@ func TestInvalidDecode(t *testing.T) {
 
 			// Wrap the signature in a MessageWrapper with an invalid magic
 			// header and test it fails
-			swmh := CreateInvalidArmor(1, string(tt.pub), sign.Namespace, sign.DefaultHashAlgorithm, sig, "INVALID")
+			swmh := CreateInvalidArmor(1, string(tt.pub), sign.DefaultNamespace, sshsig.DefaultHashAlgorithm, sig.Signature, "INVALID")
 			_, err = Decode(swmh)
 			if err == nil {
 				t.Fatalf("Decode returned no error, expected error")
@@ -319,7 +575,7 @@ func TestInvalidDecode(t *testing.T) {
 
 			// Wrap the signature in a MessageWrapper with an invalid version
 			// and test it fails
-			swv := CreateInvalidArmor(1000, string(tt.pub), sign.Namespace, sign.DefaultHashAlgorithm, sig, sign.MagicHeader)
+			swv := CreateInvalidArmor(1000, string(tt.pub), sign.DefaultNamespace, sshsig.DefaultHashAlgorithm, sig.Signature, sshsig.MagicHeader)
 			_, err = Decode(swv)
 			if err == nil {
 				t.Fatalf("Decode returned no error, expected error")
@@ -329,7 +585,7 @@ func TestInvalidDecode(t *testing.T) {
 }
 
 func CreateInvalidArmor(v uint32, pk string, ns string, ha string, sig *ssh.Signature, mh string) []byte {
-	sw := sign.WrappedSig{
+	sw := sshsig.WrappedSig{
 		Version:       v,
 		PublicKey:     pk,
 		Namespace:     ns,
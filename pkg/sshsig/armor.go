@@ -0,0 +1,93 @@
+package sshsig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	pemType = "SSH SIGNATURE"
+	// ssh-keygen wraps the base64 encoded signature at 70 columns, rather
+	// than the 64 of encoding/pem.
+	armorLineLength = 70
+)
+
+// Create an armored (PEM) signature, as written by `ssh-keygen -Y sign`.
+func Armor(sig *Signature) []byte {
+	ws := WrappedSig{
+		Version:       1,
+		PublicKey:     string(sig.PublicKey.Marshal()),
+		Namespace:     sig.Namespace,
+		HashAlgorithm: sig.HashAlgorithm,
+		Signature:     string(ssh.Marshal(sig.Signature)),
+	}
+	copy(ws.MagicHeader[:], MagicHeader)
+
+	encoded := base64.StdEncoding.EncodeToString(ssh.Marshal(ws))
+
+	var b bytes.Buffer
+	b.WriteString("-----BEGIN " + pemType + "-----\n")
+	for len(encoded) > armorLineLength {
+		b.WriteString(encoded[:armorLineLength] + "\n")
+		encoded = encoded[armorLineLength:]
+	}
+	b.WriteString(encoded + "\n")
+	b.WriteString("-----END " + pemType + "-----\n")
+	return b.Bytes()
+}
+
+// Decode an armored (PEM) signature. If invalid or unsupported data is found,
+// an error is returned.
+func Unarmor(b []byte) (*Signature, error) {
+	pemBlock, _ := pem.Decode(b)
+	if pemBlock == nil {
+		return nil, fmt.Errorf("%w: unable to decode pem file", ErrMalformedSignature)
+	}
+
+	if pemBlock.Type != pemType {
+		return nil, fmt.Errorf("%w: unsupported block type '%s'", ErrMalformedSignature, pemBlock.Type)
+	}
+
+	ws := WrappedSig{}
+	if err := ssh.Unmarshal(pemBlock.Bytes, &ws); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+
+	// Validation of the wrapped signature is done before we unpack the
+	// signature and public key. This ensures that we don't unpack
+	// malicious, invalid, or unsupported data. Instead, we can return an
+	// error before we do any unpacking.
+	if ws.Version != 1 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, ws.Version)
+	}
+	if string(ws.MagicHeader[:]) != MagicHeader {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidMagicHeader, ws.MagicHeader[:])
+	}
+	if ws.Namespace == "" {
+		return nil, ErrEmptyNamespace
+	}
+	if _, ok := hashAlgorithms[ws.HashAlgorithm]; !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedHashAlgorithm, ws.HashAlgorithm)
+	}
+
+	signature := ssh.Signature{}
+	if err := ssh.Unmarshal([]byte(ws.Signature), &signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+
+	publicKey, err := ssh.ParsePublicKey([]byte(ws.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+
+	return &Signature{
+		Signature:     &signature,
+		PublicKey:     publicKey,
+		Namespace:     ws.Namespace,
+		HashAlgorithm: ws.HashAlgorithm,
+	}, nil
+}
@@ -0,0 +1,141 @@
+package sshsig
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestArmorRoundTrip(t *testing.T) {
+	data := []byte("Hello, armor!")
+
+	for name, signer := range newTestSigners(t) {
+		t.Run(name, func(t *testing.T) {
+			sig, err := Sign(signer, "git", HashSHA256, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := Unarmor(Armor(sig))
+			if err != nil {
+				t.Fatalf("Unarmor returned an error: %v", err)
+			}
+			if decoded.Namespace != sig.Namespace || decoded.HashAlgorithm != sig.HashAlgorithm {
+				t.Errorf("Unarmor returned namespace %s and hash algorithm %s", decoded.Namespace, decoded.HashAlgorithm)
+			}
+			if !bytes.Equal(decoded.PublicKey.Marshal(), sig.PublicKey.Marshal()) {
+				t.Error("Unarmor returned a different public key")
+			}
+			if err := Verify(decoded, signer.PublicKey(), "git", bytes.NewReader(data)); err != nil {
+				t.Errorf("Verify returned an error: %v", err)
+			}
+		})
+	}
+}
+
+// Armor a wrapped signature built from the given fields of a valid one.
+func armorWrapped(ws WrappedSig, blockType string) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  blockType,
+		Bytes: ssh.Marshal(ws),
+	})
+}
+
+func TestUnarmorErrors(t *testing.T) {
+	signer := newTestSigners(t)["ed25519"]
+	sig, err := Sign(signer, "git", DefaultHashAlgorithm, bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() WrappedSig {
+		ws := WrappedSig{
+			Version:       1,
+			PublicKey:     string(sig.PublicKey.Marshal()),
+			Namespace:     sig.Namespace,
+			HashAlgorithm: sig.HashAlgorithm,
+			Signature:     string(ssh.Marshal(sig.Signature)),
+		}
+		copy(ws.MagicHeader[:], MagicHeader)
+		return ws
+	}
+
+	for _, tt := range []struct {
+		name  string
+		input func() []byte
+		err   error
+	}{
+		{
+			name:  "not pem",
+			input: func() []byte { return []byte("not a signature") },
+			err:   ErrMalformedSignature,
+		},
+		{
+			name:  "block type",
+			input: func() []byte { return armorWrapped(valid(), "SSH PRIVATE KEY") },
+			err:   ErrMalformedSignature,
+		},
+		{
+			name: "truncated",
+			input: func() []byte {
+				return pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: []byte(MagicHeader)})
+			},
+			err: ErrMalformedSignature,
+		},
+		{
+			name: "version",
+			input: func() []byte {
+				ws := valid()
+				ws.Version = 2
+				return armorWrapped(ws, pemType)
+			},
+			err: ErrUnsupportedVersion,
+		},
+		{
+			name: "magic header",
+			input: func() []byte {
+				ws := valid()
+				copy(ws.MagicHeader[:], "SSHSIX")
+				return armorWrapped(ws, pemType)
+			},
+			err: ErrInvalidMagicHeader,
+		},
+		{
+			name: "empty namespace",
+			input: func() []byte {
+				ws := valid()
+				ws.Namespace = ""
+				return armorWrapped(ws, pemType)
+			},
+			err: ErrEmptyNamespace,
+		},
+		{
+			name: "hash algorithm",
+			input: func() []byte {
+				ws := valid()
+				ws.HashAlgorithm = "sha1"
+				return armorWrapped(ws, pemType)
+			},
+			err: ErrUnsupportedHashAlgorithm,
+		},
+		{
+			name: "public key",
+			input: func() []byte {
+				ws := valid()
+				ws.PublicKey = "invalid"
+				return armorWrapped(ws, pemType)
+			},
+			err: ErrMalformedSignature,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Unarmor(tt.input())
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Unarmor returned error %v, expected %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Package sshsig creates and verifies SSH signatures in the format used by
+// `ssh-keygen -Y sign` and git, as documented at:
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+//
+// It only deals with the signature itself. Deciding whether the key that made
+// a signature is trusted, e.g. with an allowed signers file, is left to the
+// caller.
+package sshsig
+
+/*
+	The code in this package is heavily based on the great work done by the
+	sigstore/rekor project: https://github.com/sigstore/rekor/
+*/
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig#L81
+type MessageWrapper struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          string
+}
+
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig#L34
+type WrappedSig struct {
+	MagicHeader   [6]byte
+	Version       uint32
+	PublicKey     string
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     string
+}
+
+const (
+	MagicHeader          = "SSHSIG"
+	HashSHA256           = "sha256"
+	HashSHA512           = "sha512"
+	DefaultHashAlgorithm = HashSHA512
+)
+
+var (
+	ErrMalformedSignature            = errors.New("malformed signature")
+	ErrUnsupportedVersion            = errors.New("unsupported signature version")
+	ErrInvalidMagicHeader            = errors.New("invalid magic header")
+	ErrEmptyNamespace                = errors.New("namespace must not be empty")
+	ErrUnsupportedHashAlgorithm      = errors.New("unsupported hash algorithm")
+	ErrUnsupportedSignatureAlgorithm = errors.New("unsupported signature algorithm")
+	ErrNamespaceMismatch             = errors.New("signature namespace mismatch")
+	ErrPublicKeyMismatch             = errors.New("public key does not match the signature")
+	ErrIncorrectSignature            = errors.New("incorrect signature")
+)
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	HashSHA256: sha256.New,
+	HashSHA512: sha512.New,
+}
+
+// A signature along with the public key that made it and the namespace and
+// hash algorithm it was made with.
+type Signature struct {
+	Signature     *ssh.Signature
+	PublicKey     ssh.PublicKey
+	Namespace     string
+	HashAlgorithm string
+}
+
+// Sign the data with the given signer. The signature is bound to the
+// namespace, e.g. "git" or "file", so that it cannot be reused in another
+// context. The data is hashed with the hash algorithm, "sha256" or "sha512".
+func Sign(signer ssh.AlgorithmSigner, namespace, hashAlg string, data io.Reader) (*Signature, error) {
+	if namespace == "" {
+		return nil, ErrEmptyNamespace
+	}
+
+	message, err := signedMessage(namespace, hashAlg, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// ssh-rsa is not supported for RSA keys:
+	// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig#L71
+	// RSA keys sign with the SHA-2 algorithm matching the hash algorithm, so
+	// that verifiers only need one hash. We can use the default value of ""
+	// for other key types though.
+	algo := ""
+	if keyType(signer.PublicKey()) == ssh.KeyAlgoRSA {
+		algo = ssh.KeyAlgoRSASHA512
+		if hashAlg == HashSHA256 {
+			algo = ssh.KeyAlgoRSASHA256
+		}
+	}
+	sig, err := signer.SignWithAlgorithm(rand.Reader, message, algo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signature{
+		Signature:     sig,
+		PublicKey:     signer.PublicKey(),
+		Namespace:     namespace,
+		HashAlgorithm: hashAlg,
+	}, nil
+}
+
+// Returns the type of the key, or of the key certified by a certificate, which
+// decides the signature algorithm of the certificate.
+func keyType(key ssh.PublicKey) string {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return cert.Key.Type()
+	}
+	return key.Type()
+}
+
+// Verify the signature of the data in the namespace with the given public
+// key, which must be the key recorded in the signature. The data is hashed
+// with the hash algorithm recorded in the signature and wrapped exactly as it
+// was when signed.
+//
+// Verify does not decide whether the key is trusted; the caller must check
+// that the public key belongs to an expected signer.
+func Verify(sig *Signature, pubKey ssh.PublicKey, namespace string, data io.Reader) error {
+	if sig.Namespace != namespace {
+		return fmt.Errorf("%w: signature is for '%s', not '%s'", ErrNamespaceMismatch, sig.Namespace, namespace)
+	}
+
+	if !bytes.Equal(pubKey.Marshal(), sig.PublicKey.Marshal()) {
+		return ErrPublicKeyMismatch
+	}
+
+	// ssh-rsa (SHA-1) signatures are not permitted:
+	// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig#L71
+	if sig.Signature.Format == ssh.KeyAlgoRSA {
+		return fmt.Errorf("%w: '%s'", ErrUnsupportedSignatureAlgorithm, sig.Signature.Format)
+	}
+
+	message, err := signedMessage(namespace, sig.HashAlgorithm, data)
+	if err != nil {
+		return err
+	}
+
+	if err := pubKey.Verify(message, sig.Signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrIncorrectSignature, err)
+	}
+	return nil
+}
+
+// Hash the data and wrap it in a MessageWrapper, preceded by the magic
+// header, which is the message that is actually signed.
+func signedMessage(namespace, hashAlg string, data io.Reader) ([]byte, error) {
+	hf, ok := hashAlgorithms[hashAlg]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedHashAlgorithm, hashAlg)
+	}
+
+	h := hf()
+	if _, err := io.Copy(h, data); err != nil {
+		return nil, err
+	}
+
+	mw := MessageWrapper{
+		Namespace:     namespace,
+		HashAlgorithm: hashAlg,
+		Hash:          string(h.Sum(nil)),
+	}
+	return append([]byte(MagicHeader), ssh.Marshal(mw)...), nil
+}